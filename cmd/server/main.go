@@ -1,27 +1,68 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/http"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/services"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	energy_meter_worker "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/energy_meter"
+	generic_worker "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/generic"
 	hydroponic_manager_worker "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager"
 	water_meter_worker "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter"
 )
 
 type Config struct {
-	DatabaseUrl string `env:"DATABASE_URL"`
-	ClientId    string `env:"MQTT_CLIENT_ID"`
+	DatabaseUrl           string `env:"DATABASE_URL"`
+	ClientId              string `env:"MQTT_CLIENT_ID"`
+	BrokerUrl             string `env:"BROKER_URL"`
+	Username              string `env:"MQTT_USERNAME"`
+	Password              string `env:"MQTT_PASSWORD"`
+	CaFilePath            string `env:"MQTT_CA_FILE"`
+	ClientCrtFilePath     string `env:"MQTT_CLIENT_CERT_FILE"`
+	ClientKeyFilePath     string `env:"MQTT_CLIENT_KEY_FILE"`
+	StatusTopic           string `env:"MQTT_STATUS_TOPIC"`
+	PersistentSession     bool   `env:"MQTT_PERSISTENT_SESSION"`
+	StoreDirectory        string `env:"MQTT_STORE_DIR"`
+	DefaultQoS            byte   `env:"MQTT_DEFAULT_QOS"`
+	SharedGroup           string `env:"MQTT_SHARED_GROUP"`
+	ProtocolVersion       uint   `env:"MQTT_PROTOCOL_VERSION"`
+	TLSServerName         string `env:"MQTT_TLS_SERVER_NAME"`
+	TLSMinVersion         uint16 `env:"MQTT_TLS_MIN_VERSION"`
+	TLSInsecureSkipVerify bool   `env:"MQTT_TLS_INSECURE_SKIP_VERIFY"`
+
+	PipelineWorkers        int                     `env:"MQTT_PIPELINE_WORKERS"`
+	PipelineQueueSize      int                     `env:"MQTT_PIPELINE_QUEUE_SIZE"`
+	PipelineOverflowPolicy services.OverflowPolicy `env:"MQTT_PIPELINE_OVERFLOW_POLICY"`
+
+	CommandAckTimeout    time.Duration `env:"COMMAND_ACK_TIMEOUT"`
+	CommandMaxRetries    int           `env:"COMMAND_MAX_RETRIES"`
+	CommandCheckInterval time.Duration `env:"COMMAND_CHECK_INTERVAL"`
+
+	RawArchiveEnabled bool `env:"RAW_ARCHIVE_ENABLED"`
+
+	DeviceDefinitionsDir string `env:"DEVICE_DEFINITIONS_DIR"`
+
+	HomeAssistantDiscoveryEnabled bool   `env:"HOME_ASSISTANT_DISCOVERY_ENABLED"`
+	HomeAssistantDiscoveryPrefix  string `env:"HOME_ASSISTANT_DISCOVERY_PREFIX"`
+	HomeAssistantStatePrefix      string `env:"HOME_ASSISTANT_STATE_PREFIX"`
 }
 type Instance struct {
-	Config     Config
-	Database   *database.Database
-	MQTTClient *services.MQTTClient
-	HTTPServer *http.Server
+	Config         Config
+	Database       *database.Database
+	MQTTClient     *services.MQTTClient
+	Registry       *workers.Registry
+	CommandTracker *workers.CommandTracker
+	HTTPServer     *http.Server
 }
 
 var instance *Instance
@@ -31,17 +72,61 @@ func GetInstance() *Instance {
 		config := loadEnv()
 		db := database.New()
 
+		mqttClient := services.NewMQTTClient(services.MQTTConfig{
+			BrokerUrl:         config.BrokerUrl,
+			ClientId:          config.ClientId,
+			Username:          config.Username,
+			Password:          config.Password,
+			CaFilePath:        config.CaFilePath,
+			ClientCrtFilePath: config.ClientCrtFilePath,
+			ClientKeyFilePath: config.ClientKeyFilePath,
+			StatusTopic:       config.StatusTopic,
+			PersistentSession: config.PersistentSession,
+			StoreDirectory:    config.StoreDirectory,
+			DefaultQoS:        config.DefaultQoS,
+
+			SharedSubscriptionGroup: config.SharedGroup,
+			ProtocolVersion:         config.ProtocolVersion,
+
+			TLSServerName:         config.TLSServerName,
+			TLSMinVersion:         config.TLSMinVersion,
+			TLSInsecureSkipVerify: config.TLSInsecureSkipVerify,
+
+			Pipeline: services.PipelineConfig{
+				Workers:        config.PipelineWorkers,
+				QueueSize:      config.PipelineQueueSize,
+				OverflowPolicy: config.PipelineOverflowPolicy,
+			},
+		})
+
+		// Workers subscribe before the MQTT client connects so messages queued
+		// in the persistent session are routed as soon as they arrive
+		registry := workers.NewRegistry(db, mqttClient)
+		registerDeviceWorkers(registry, mqttClient, config.DeviceDefinitionsDir)
+		if config.RawArchiveEnabled {
+			registry.EnableRawArchive()
+		}
+		registry.SetCommandAckTimeout(config.CommandAckTimeout)
+		if config.HomeAssistantDiscoveryEnabled {
+			discovery := workers.NewHomeAssistantDiscovery(db, mqttClient, registry, workers.HomeAssistantConfig{
+				DiscoveryPrefix: config.HomeAssistantDiscoveryPrefix,
+				StatePrefix:     config.HomeAssistantStatePrefix,
+			})
+			err := discovery.Register()
+			AssertOrExit(err, "Failed to register Home Assistant discovery")
+		}
+
 		instance = &Instance{
-			Config:   config,
-			Database: db,
-			MQTTClient: services.NewMQTTClient(services.MQTTConfig{
-				BrokerUrl:         "mqtts://mosquitto.trindademedia.dev:8883",
-				ClientId:          config.ClientId, 
-				CaFilePath:        "./certs/ca.crt",
-				ClientCrtFilePath: "./certs/client.crt",
-				ClientKeyFilePath: "./certs/client.key",
+			Config:     config,
+			Database:   db,
+			MQTTClient: mqttClient,
+			Registry:   registry,
+			CommandTracker: workers.NewCommandTracker(db, registry, workers.CommandRetryPolicy{
+				AckTimeout:    config.CommandAckTimeout,
+				MaxRetries:    config.CommandMaxRetries,
+				CheckInterval: config.CommandCheckInterval,
 			}),
-			HTTPServer: http.NewServer(3000, db),
+			HTTPServer: http.NewServer(3000, db, registry),
 		}
 	}
 
@@ -49,6 +134,11 @@ func GetInstance() *Instance {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+
 	fmt.Println("[MQTT Worker] Starting Worker")
 
 	instance = GetInstance()
@@ -62,11 +152,93 @@ func main() {
 	err = instance.MQTTClient.Start()
 	AssertOrExit(err, "Failed to start MQTT worker")
 
-	hydroponic_manager_worker.NewHydroponicManagerListener(instance.Database, instance.MQTTClient)
-	water_meter_worker.NewWaterLevelMeterListener(instance.Database, instance.MQTTClient)
+	instance.CommandTracker.Start()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	for instance.MQTTClient.IsRunning() {
-		time.Sleep(200 * time.Millisecond)
+		select {
+		case sig := <-stop:
+			fmt.Printf("[MQTT Worker] Received %s, shutting down\n", sig)
+			instance.CommandTracker.Stop()
+			instance.MQTTClient.Stop()
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// runReplay feeds archived raw messages back through the device workers
+// without connecting to the broker or serving HTTP.
+//
+// Usage: server replay -start <RFC3339> -end <RFC3339> [-device <fuse id>] [-dry-run] [-replace]
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	start := flags.String("start", "", "start of the receive time range (RFC3339)")
+	end := flags.String("end", "", "end of the receive time range (RFC3339), defaults to now")
+	device := flags.String("device", "", "only replay the messages of this fuse ID")
+	dryRun := flags.Bool("dry-run", false, "parse and encode the messages without writing them")
+	replace := flags.Bool("replace", false, "overwrite the readings stored for the replayed messages instead of skipping them")
+	flags.Parse(args)
+
+	startTime, err := time.Parse(time.RFC3339, *start)
+	AssertOrExit(err, "Invalid -start %q, use RFC3339", *start)
+
+	endTime := time.Now()
+	if *end != "" {
+		endTime, err = time.Parse(time.RFC3339, *end)
+		AssertOrExit(err, "Invalid -end %q, use RFC3339", *end)
+	}
+
+	config := loadEnv()
+	db := database.New()
+	err = db.Connect(config.DatabaseUrl)
+	AssertOrExit(err, "Failed to connect to database with URL: %s", config.DatabaseUrl)
+
+	err = db.RunMigrations()
+	AssertOrExit(err, "Failed to run database migrations")
+
+	// The client is never started, the workers only need it to be constructed
+	mqttClient := services.NewMQTTClient(services.MQTTConfig{ClientId: config.ClientId})
+	registry := workers.NewRegistry(db, mqttClient)
+	registerDeviceWorkers(registry, mqttClient, config.DeviceDefinitionsDir)
+
+	stats, err := registry.Replay(context.Background(), workers.ReplayOptions{
+		Start:   startTime,
+		End:     endTime,
+		FuseID:  *device,
+		DryRun:  *dryRun,
+		Replace: *replace,
+	})
+	AssertOrExit(err, "Replay failed")
+
+	fmt.Printf("[MQTT Worker] Replay finished: %d messages, %d replayed, %d skipped, %d failed, %d already stored\n",
+		stats.Messages, stats.Replayed, stats.Skipped, stats.Failed, stats.Existing)
+}
+
+func registerDeviceWorkers(registry *workers.Registry, client *services.MQTTClient, definitionsDir string) {
+	deviceWorkers := []workers.DeviceWorker{
+		hydroponic_manager_worker.NewHydroponicManagerWorker(client),
+		water_meter_worker.NewWaterLevelMeterWorker(client),
+		energy_meter_worker.NewEnergyMeterWorker(client),
+	}
+
+	// Device types described by definition files use the generic worker
+	if definitionsDir != "" {
+		definitions, err := generic_worker.LoadDefinitions(definitionsDir)
+		AssertOrExit(err, "Failed to load device definitions from %s", definitionsDir)
+
+		for _, definition := range definitions {
+			worker, err := generic_worker.NewGenericWorker(definition)
+			AssertOrExit(err, "Invalid device definition %s", definition.Type)
+			deviceWorkers = append(deviceWorkers, worker)
+		}
+	}
+
+	for _, worker := range deviceWorkers {
+		err := registry.Register(worker)
+		AssertOrExit(err, "Failed to register device worker %s", worker.Info().Type)
 	}
 }
 
@@ -88,8 +260,88 @@ func loadEnv() Config {
 		fmt.Println("No .env file found, reading configuration from environment variables")
 	}
 
+	tlsMinVersion, err := services.ParseTLSVersion(os.Getenv("MQTT_TLS_MIN_VERSION"))
+	AssertOrExit(err, "Invalid MQTT_TLS_MIN_VERSION")
+
+	overflowPolicy, err := services.ParseOverflowPolicy(os.Getenv("MQTT_PIPELINE_OVERFLOW_POLICY"))
+	AssertOrExit(err, "Invalid MQTT_PIPELINE_OVERFLOW_POLICY")
+
 	return Config{
-		DatabaseUrl: os.Getenv("DATABASE_URL"),
-		ClientId:    os.Getenv("MQTT_CLIENT_ID"),
+		DatabaseUrl:           os.Getenv("DATABASE_URL"),
+		ClientId:              os.Getenv("MQTT_CLIENT_ID"),
+		BrokerUrl:             getEnv("BROKER_URL", "mqtts://mosquitto.trindademedia.dev:8883"),
+		Username:              os.Getenv("MQTT_USERNAME"),
+		Password:              os.Getenv("MQTT_PASSWORD"),
+		CaFilePath:            getEnv("MQTT_CA_FILE", "./certs/ca.crt"),
+		ClientCrtFilePath:     getEnv("MQTT_CLIENT_CERT_FILE", "./certs/client.crt"),
+		ClientKeyFilePath:     getEnv("MQTT_CLIENT_KEY_FILE", "./certs/client.key"),
+		StatusTopic:           os.Getenv("MQTT_STATUS_TOPIC"),
+		PersistentSession:     getEnvBool("MQTT_PERSISTENT_SESSION", true),
+		StoreDirectory:        getEnv("MQTT_STORE_DIR", "./data/mqtt-store"),
+		DefaultQoS:            byte(getEnvInt("MQTT_DEFAULT_QOS", 1)),
+		SharedGroup:           os.Getenv("MQTT_SHARED_GROUP"),
+		ProtocolVersion:       uint(getEnvInt("MQTT_PROTOCOL_VERSION", 4)),
+		TLSServerName:         os.Getenv("MQTT_TLS_SERVER_NAME"),
+		TLSMinVersion:         tlsMinVersion,
+		TLSInsecureSkipVerify: getEnvBool("MQTT_TLS_INSECURE_SKIP_VERIFY", false),
+
+		PipelineWorkers:        getEnvInt("MQTT_PIPELINE_WORKERS", services.DefaultPipelineWorkers),
+		PipelineQueueSize:      getEnvInt("MQTT_PIPELINE_QUEUE_SIZE", services.DefaultPipelineQueueSize),
+		PipelineOverflowPolicy: overflowPolicy,
+
+		CommandAckTimeout:    getEnvDuration("COMMAND_ACK_TIMEOUT", workers.DefaultCommandAckTimeout),
+		CommandMaxRetries:    getEnvInt("COMMAND_MAX_RETRIES", 0),
+		CommandCheckInterval: getEnvDuration("COMMAND_CHECK_INTERVAL", workers.DefaultCommandCheckInterval),
+
+		RawArchiveEnabled: getEnvBool("RAW_ARCHIVE_ENABLED", false),
+
+		DeviceDefinitionsDir: os.Getenv("DEVICE_DEFINITIONS_DIR"),
+
+		HomeAssistantDiscoveryEnabled: getEnvBool("HOME_ASSISTANT_DISCOVERY_ENABLED", false),
+		HomeAssistantDiscoveryPrefix:  getEnv("HOME_ASSISTANT_DISCOVERY_PREFIX", workers.DefaultHomeAssistantDiscoveryPrefix),
+		HomeAssistantStatePrefix:      getEnv("HOME_ASSISTANT_STATE_PREFIX", workers.DefaultHomeAssistantStatePrefix),
 	}
 }
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	AssertOrExit(err, "Invalid integer value for %s: %s", key, value)
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	AssertOrExit(err, "Invalid duration value for %s: %s", key, value)
+	return parsed
+}
+
+// getEnv returns the fallback only when the variable is unset, so an explicitly
+// empty value (e.g. no client certificate) is preserved.
+func getEnv(key string, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	AssertOrExit(err, "Invalid boolean value for %s: %s", key, value)
+	return parsed
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	CommandStatusPending      = "pending"
+	CommandStatusSent         = "sent"
+	CommandStatusAcknowledged = "acknowledged"
+	CommandStatusFailed       = "failed"
+	CommandStatusTimedOut     = "timed_out"
+)
+
+const deviceCommandColumns = `
+	id, device_id, command, args, COALESCE(topic, ''), payload, sent_by, status, COALESCE(error, ''),
+	attempts, acknowledged_at, redacted, created_at, updated_at`
+
+type DeviceCommand struct {
+	ID             int        `json:"id"`
+	DeviceID       int        `json:"device_id"`
+	Command        string     `json:"command"`
+	Args           string     `json:"args"`
+	Topic          string     `json:"topic"`
+	Payload        string     `json:"payload"`
+	SentBy         string     `json:"sent_by"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error"`
+	Attempts       int        `json:"attempts"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	// Redacted commands had secrets replaced in args and payload, so they
+	// are never republished from the stored payload
+	Redacted  bool      `json:"redacted"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CommandRepository struct {
+	db *Database
+}
+
+func newCommandRepository(db *Database) *CommandRepository {
+	return &CommandRepository{db: db}
+}
+
+func scanDeviceCommand(row pgx.Row) (*DeviceCommand, error) {
+	var command DeviceCommand
+	err := row.Scan(
+		&command.ID,
+		&command.DeviceID,
+		&command.Command,
+		&command.Args,
+		&command.Topic,
+		&command.Payload,
+		&command.SentBy,
+		&command.Status,
+		&command.Error,
+		&command.Attempts,
+		&command.AcknowledgedAt,
+		&command.Redacted,
+		&command.CreatedAt,
+		&command.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+func scanDeviceCommands(rows pgx.Rows) ([]DeviceCommand, error) {
+	defer rows.Close()
+
+	commands := []DeviceCommand{}
+	for rows.Next() {
+		command, err := scanDeviceCommand(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device command: %w", err)
+		}
+		commands = append(commands, *command)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over device commands: %w", err)
+	}
+
+	return commands, nil
+}
+
+// NextCommandID reserves the ID of the next command so it can be embedded in
+// the payload as its sequence before the row is inserted.
+func (r *CommandRepository) NextCommandID(ctx context.Context) (int, error) {
+	var id int
+	err := r.db.pool.QueryRow(ctx, `SELECT nextval(pg_get_serial_sequence('device_commands', 'id'))`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve device command id: %w", err)
+	}
+	return id, nil
+}
+
+func (r *CommandRepository) InsertCommand(ctx context.Context, id int, deviceID int, command, args, topic, payload, sentBy string, redacted bool) (*DeviceCommand, error) {
+	deviceCommand, err := scanDeviceCommand(r.db.pool.QueryRow(ctx, `
+		INSERT INTO device_commands
+			(id, device_id, command, args, topic, payload, sent_by, status, redacted)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING`+deviceCommandColumns,
+		id, deviceID, command, args, topic, payload, sentBy, CommandStatusPending, redacted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert device command: %w", err)
+	}
+
+	return deviceCommand, nil
+}
+
+func (r *CommandRepository) UpdateCommandStatus(ctx context.Context, commandID int, status string, errorMessage string) error {
+	_, err := r.db.pool.Exec(ctx, `
+		UPDATE device_commands
+		SET status = $2, error = NULLIF($3, ''), updated_at = NOW()
+		WHERE id = $1
+	`, commandID, status, errorMessage)
+	if err != nil {
+		return fmt.Errorf("failed to update device command %d: %w", commandID, err)
+	}
+
+	return nil
+}
+
+// AcknowledgeCommand applies a device ack to the command, returning false when
+// no command with the ID was sent to the device. Late acks of timed out
+// commands are still recorded since the device did apply them.
+func (r *CommandRepository) AcknowledgeCommand(ctx context.Context, commandID int, fuseID string, success bool, message string) (bool, error) {
+	status := CommandStatusAcknowledged
+	if !success {
+		status = CommandStatusFailed
+	}
+
+	tag, err := r.db.pool.Exec(ctx, `
+		UPDATE device_commands
+		SET status = $3, error = NULLIF($4, ''), acknowledged_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+			AND device_id = (SELECT id FROM devices WHERE fuseId = $2)
+			AND status IN ($5, $6)
+	`, commandID, fuseID, status, message, CommandStatusSent, CommandStatusTimedOut)
+	if err != nil {
+		return false, fmt.Errorf("failed to acknowledge device command %d: %w", commandID, err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// ClaimCommandsForRetry returns the sent commands without an ack after
+// timeout that still have attempts left, bumping their attempts so other
+// replicas do not retry them too.
+func (r *CommandRepository) ClaimCommandsForRetry(ctx context.Context, timeout time.Duration, maxAttempts int) ([]DeviceCommand, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		UPDATE device_commands
+		SET attempts = attempts + 1, updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM device_commands
+			WHERE status = $1 AND updated_at < NOW() - make_interval(secs => $2) AND attempts < $3 AND NOT redacted
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING`+deviceCommandColumns,
+		CommandStatusSent, timeout.Seconds(), maxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim device commands for retry: %w", err)
+	}
+
+	return scanDeviceCommands(rows)
+}
+
+// ExpireCommands marks the sent commands without an ack after timeout and no
+// attempts left as timed out. Redacted commands cannot be republished, so
+// they time out after the first attempt.
+func (r *CommandRepository) ExpireCommands(ctx context.Context, timeout time.Duration, maxAttempts int) ([]DeviceCommand, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		UPDATE device_commands
+		SET status = $1, updated_at = NOW()
+		WHERE status = $2 AND updated_at < NOW() - make_interval(secs => $3) AND (attempts >= $4 OR redacted)
+		RETURNING`+deviceCommandColumns,
+		CommandStatusTimedOut, CommandStatusSent, timeout.Seconds(), maxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire device commands: %w", err)
+	}
+
+	return scanDeviceCommands(rows)
+}
+
+func (r *CommandRepository) GetCommandsByDeviceID(ctx context.Context, deviceID int, limit int) ([]DeviceCommand, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT`+deviceCommandColumns+`
+		FROM device_commands
+		WHERE device_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, deviceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device commands: %w", err)
+	}
+
+	return scanDeviceCommands(rows)
+}
@@ -7,9 +7,14 @@ import (
 )
 
 type Database struct {
-	pool             *pgxpool.Pool
-	sensorRepository *SensorRepository
-	deviceRepository *DeviceRepository
+	pool                 *pgxpool.Pool
+	sensorRepository     *SensorRepository
+	deviceRepository     *DeviceRepository
+	commandRepository    *CommandRepository
+	thresholdRepository  *ThresholdRepository
+	deadLetterRepository *DeadLetterRepository
+	rawMessageRepository *RawMessageRepository
+	tankRepository       *TankRepository
 }
 
 func New() *Database {
@@ -39,6 +44,41 @@ func (db *Database) DeviceRepository() *DeviceRepository {
 	return db.deviceRepository
 }
 
+func (db *Database) CommandRepository() *CommandRepository {
+	if db.commandRepository == nil {
+		db.commandRepository = newCommandRepository(db)
+	}
+	return db.commandRepository
+}
+
+func (db *Database) ThresholdRepository() *ThresholdRepository {
+	if db.thresholdRepository == nil {
+		db.thresholdRepository = newThresholdRepository(db)
+	}
+	return db.thresholdRepository
+}
+
+func (db *Database) DeadLetterRepository() *DeadLetterRepository {
+	if db.deadLetterRepository == nil {
+		db.deadLetterRepository = newDeadLetterRepository(db)
+	}
+	return db.deadLetterRepository
+}
+
+func (db *Database) RawMessageRepository() *RawMessageRepository {
+	if db.rawMessageRepository == nil {
+		db.rawMessageRepository = newRawMessageRepository(db)
+	}
+	return db.rawMessageRepository
+}
+
+func (db *Database) TankRepository() *TankRepository {
+	if db.tankRepository == nil {
+		db.tankRepository = newTankRepository(db)
+	}
+	return db.tankRepository
+}
+
 func (db *Database) Close() error {
 	db.pool.Close()
 	return nil
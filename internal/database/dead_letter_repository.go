@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DeadLetter is an MQTT message the registry failed to ingest, kept so it can
+// be reprocessed once the cause is fixed.
+type DeadLetter struct {
+	ID            int        `json:"id"`
+	Topic         string     `json:"topic"`
+	DeviceType    string     `json:"device_type"`
+	Payload       []byte     `json:"payload"`
+	PayloadText   string     `json:"payload_text,omitempty"`
+	Error         string     `json:"error"`
+	Attempts      int        `json:"attempts"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ReprocessedAt *time.Time `json:"reprocessed_at"`
+}
+
+type DeadLetterRepository struct {
+	db *Database
+}
+
+func newDeadLetterRepository(db *Database) *DeadLetterRepository {
+	return &DeadLetterRepository{db: db}
+}
+
+func scanDeadLetter(row pgx.Row) (*DeadLetter, error) {
+	var deadLetter DeadLetter
+	err := row.Scan(
+		&deadLetter.ID,
+		&deadLetter.Topic,
+		&deadLetter.DeviceType,
+		&deadLetter.Payload,
+		&deadLetter.Error,
+		&deadLetter.Attempts,
+		&deadLetter.CreatedAt,
+		&deadLetter.ReprocessedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Text payloads are also returned readable, binary ones only as base64
+	if utf8.Valid(deadLetter.Payload) {
+		deadLetter.PayloadText = string(deadLetter.Payload)
+	}
+
+	return &deadLetter, nil
+}
+
+func (r *DeadLetterRepository) InsertDeadLetter(ctx context.Context, topic, deviceType string, payload []byte, errorMessage string) error {
+	_, err := r.db.pool.Exec(ctx, `
+		INSERT INTO dead_letters
+			(topic, device_type, payload, error)
+		VALUES
+			($1, $2, $3, $4)
+	`, topic, deviceType, payload, errorMessage)
+	if err != nil {
+		return fmt.Errorf("failed to insert dead letter: %w", err)
+	}
+
+	return nil
+}
+
+func (r *DeadLetterRepository) GetDeadLetterByID(ctx context.Context, id int) (*DeadLetter, error) {
+	deadLetter, err := scanDeadLetter(r.db.pool.QueryRow(ctx, `
+		SELECT id, topic, device_type, payload, error, attempts, created_at, reprocessed_at
+		FROM dead_letters
+		WHERE id = $1
+	`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letter %d: %w", id, err)
+	}
+
+	return deadLetter, nil
+}
+
+// GetDeadLetters returns the newest dead letters, only the ones not yet
+// reprocessed when pendingOnly is set.
+func (r *DeadLetterRepository) GetDeadLetters(ctx context.Context, pendingOnly bool, limit int) ([]DeadLetter, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT id, topic, device_type, payload, error, attempts, created_at, reprocessed_at
+		FROM dead_letters
+		WHERE NOT $1 OR reprocessed_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, pendingOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	deadLetters := []DeadLetter{}
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		deadLetters = append(deadLetters, *deadLetter)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over dead letters: %w", err)
+	}
+
+	return deadLetters, nil
+}
+
+// RecordReprocessAttempt counts a reprocess attempt, marking the dead letter
+// as reprocessed when errorMessage is empty or storing the new error otherwise.
+func (r *DeadLetterRepository) RecordReprocessAttempt(ctx context.Context, id int, errorMessage string) error {
+	_, err := r.db.pool.Exec(ctx, `
+		UPDATE dead_letters
+		SET attempts = attempts + 1,
+			error = CASE WHEN $2 = '' THEN error ELSE $2 END,
+			reprocessed_at = CASE WHEN $2 = '' THEN NOW() ELSE NULL END
+		WHERE id = $1
+	`, id, errorMessage)
+	if err != nil {
+		return fmt.Errorf("failed to update dead letter %d: %w", id, err)
+	}
+
+	return nil
+}
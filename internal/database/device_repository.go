@@ -17,6 +17,18 @@ type Device struct {
 	Description    string    `json:"description"`
 	LastSeen       time.Time `json:"last_seen"`
 	CreatedAt      time.Time `json:"created_at"`
+
+	FirmwareVersion string `json:"firmware_version"`
+	UptimeSeconds   int64  `json:"uptime_seconds"`
+	CropID          *int   `json:"crop_id"`
+}
+
+type DeviceHealth struct {
+	WifiStrength    int       `json:"wifi_strength"`
+	BatteryPercent  int       `json:"battery_percent"`
+	FirmwareVersion string    `json:"firmware_version"`
+	UptimeSeconds   int64     `json:"uptime_seconds"`
+	CreatedAt       time.Time `json:"created_at"`
 }
 
 type DeviceRepository struct {
@@ -35,7 +47,8 @@ func (r *DeviceRepository) InsertDevice(ctx context.Context, fuseID, name, descr
 		VALUES 
 			($1, $2, $3, $4, $5, $6, $7, NOW())
 		RETURNING 
-			id, fuseId, name, description, created_at, location, type, wifi_strength, battery_percent, last_seen
+			id, fuseId, name, description, created_at, location, type, wifi_strength, battery_percent, last_seen,
+			COALESCE(firmware_version, ''), COALESCE(uptime_seconds, 0), crop_id
 	`, fuseID, name, description, location, deviceType, wifiStrength, batteryPercent).Scan(
 		&device.ID,
 		&device.FuseID,
@@ -47,6 +60,9 @@ func (r *DeviceRepository) InsertDevice(ctx context.Context, fuseID, name, descr
 		&device.WifiStrength,
 		&device.BatteryPercent,
 		&device.LastSeen,
+		&device.FirmwareVersion,
+		&device.UptimeSeconds,
+		&device.CropID,
 	)
 
 	if err != nil {
@@ -60,12 +76,13 @@ func (r *DeviceRepository) GetDeviceByFuseID(ctx context.Context, fuseID string)
 	var device Device
 	err := r.db.pool.QueryRow(ctx, `
 		SELECT 
-			id, fuseId, name, description, created_at, location, type, wifi_strength, battery_percent
+			id, fuseId, name, description, created_at, location, type, wifi_strength, battery_percent,
+			COALESCE(firmware_version, ''), COALESCE(uptime_seconds, 0), crop_id
 		FROM 
 			devices 
 		WHERE 
 			fuseId = $1
-	`, fuseID).Scan(&device.ID, &device.FuseID, &device.Name, &device.Description, &device.CreatedAt, &device.Location, &device.Type, &device.WifiStrength, &device.BatteryPercent)
+	`, fuseID).Scan(&device.ID, &device.FuseID, &device.Name, &device.Description, &device.CreatedAt, &device.Location, &device.Type, &device.WifiStrength, &device.BatteryPercent, &device.FirmwareVersion, &device.UptimeSeconds, &device.CropID)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to query device: %w", err)
@@ -83,7 +100,8 @@ func (r *DeviceRepository) GetDevicesByFuseID(ctx context.Context, fuseIds []str
 
 	rows, err := r.db.pool.Query(ctx, `
 		SELECT
-			id, fuseId, name, description, created_at, location, type, wifi_strength, battery_percent, last_seen
+			id, fuseId, name, description, created_at, location, type, wifi_strength, battery_percent, last_seen,
+			COALESCE(firmware_version, ''), COALESCE(uptime_seconds, 0), crop_id
 		FROM
 			devices
 		WHERE
@@ -107,7 +125,10 @@ func (r *DeviceRepository) GetDevicesByFuseID(ctx context.Context, fuseIds []str
 			&device.Type,
 			&device.WifiStrength,
 			&device.BatteryPercent,
-			&device.LastSeen)
+			&device.LastSeen,
+			&device.FirmwareVersion,
+			&device.UptimeSeconds,
+			&device.CropID)
 
 		fmt.Println(device)
 
@@ -120,6 +141,131 @@ func (r *DeviceRepository) GetDevicesByFuseID(ctx context.Context, fuseIds []str
 	return devices, nil
 }
 
+func (r *DeviceRepository) GetDevices(ctx context.Context) ([]Device, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT
+			id, fuseId, name, description, created_at, location, type, wifi_strength, battery_percent, last_seen,
+			COALESCE(firmware_version, ''), COALESCE(uptime_seconds, 0), crop_id
+		FROM
+			devices
+		ORDER BY
+			id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := make([]Device, 0)
+	for rows.Next() {
+		var device Device
+		err := rows.Scan(&device.ID,
+			&device.FuseID,
+			&device.Name,
+			&device.Description,
+			&device.CreatedAt,
+			&device.Location,
+			&device.Type,
+			&device.WifiStrength,
+			&device.BatteryPercent,
+			&device.LastSeen,
+			&device.FirmwareVersion,
+			&device.UptimeSeconds,
+			&device.CropID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return devices, nil
+}
+
+// SetDeviceCrop assigns the crop whose thresholds apply to the device, or
+// clears it when cropID is nil.
+func (r *DeviceRepository) SetDeviceCrop(ctx context.Context, deviceID int, cropID *int) error {
+	_, err := r.db.pool.Exec(ctx, `
+		UPDATE devices
+		SET crop_id = $2
+		WHERE id = $1
+	`, deviceID, cropID)
+	if err != nil {
+		return fmt.Errorf("failed to set crop of device %d: %w", deviceID, err)
+	}
+
+	return nil
+}
+
+// UpdateDeviceHealth stores the latest health values on the device and keeps
+// them in device_health_history for trending.
+func (r *DeviceRepository) UpdateDeviceHealth(ctx context.Context, deviceID int, health DeviceHealth) error {
+	_, err := r.db.pool.Exec(ctx, `
+		WITH inserted_health AS (
+			INSERT INTO device_health_history
+				(device_id, wifi_strength, battery_percent, firmware_version, uptime_seconds)
+			VALUES
+				($1, $2, $3, $4, $5)
+		)
+		UPDATE devices
+		SET wifi_strength = $2, battery_percent = $3, firmware_version = $4, uptime_seconds = $5
+		WHERE id = $1;
+	`, deviceID, health.WifiStrength, health.BatteryPercent, health.FirmwareVersion, health.UptimeSeconds)
+	if err != nil {
+		return fmt.Errorf("failed to update device health: %w", err)
+	}
+
+	return nil
+}
+
+// InsertDeviceHealthHistory records the health reported at createdAt without
+// touching the current health of the device, used for stored messages.
+func (r *DeviceRepository) InsertDeviceHealthHistory(ctx context.Context, deviceID int, health DeviceHealth, createdAt time.Time) error {
+	_, err := r.db.pool.Exec(ctx, `
+		INSERT INTO device_health_history
+			(device_id, wifi_strength, battery_percent, firmware_version, uptime_seconds, created_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6);
+	`, deviceID, health.WifiStrength, health.BatteryPercent, health.FirmwareVersion, health.UptimeSeconds, createdAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert device health history: %w", err)
+	}
+
+	return nil
+}
+
+func (r *DeviceRepository) GetDeviceHealthHistory(ctx context.Context, deviceID int, startTime time.Time, endTime time.Time) ([]DeviceHealth, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT wifi_strength, battery_percent, COALESCE(firmware_version, ''), uptime_seconds, created_at
+		FROM device_health_history
+		WHERE device_id = $1 AND created_at >= $2 AND created_at <= $3
+		ORDER BY created_at ASC
+	`, deviceID, startTime, endTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device health history: %w", err)
+	}
+	defer rows.Close()
+
+	history := make([]DeviceHealth, 0)
+	for rows.Next() {
+		var health DeviceHealth
+		err := rows.Scan(&health.WifiStrength, &health.BatteryPercent, &health.FirmwareVersion, &health.UptimeSeconds, &health.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device health: %w", err)
+		}
+		history = append(history, health)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return history, nil
+}
+
 func (dr *DeviceRepository) CreateAndGetDeviceIfDoesNotExist(fuseId string, name, description, location, deviceType string, wifiStrength, batteryPercent int) (*Device, error) {
 	ctx := context.Background()
 
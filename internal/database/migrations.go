@@ -54,6 +54,96 @@ func (db *Database) RunMigrations() error {
 		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS battery_percent INT;`,
 		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS type VARCHAR(50);`,
 		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen TIMESTAMPTZ DEFAULT NOW();`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS firmware_version VARCHAR(64);`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS uptime_seconds BIGINT;`,
+		`CREATE TABLE IF NOT EXISTS device_health_history (
+			id SERIAL PRIMARY KEY,
+			device_id INT REFERENCES devices(id) ON DELETE CASCADE,
+			wifi_strength INT,
+			battery_percent INT,
+			firmware_version VARCHAR(64),
+			uptime_seconds BIGINT,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);`,
+		`CREATE INDEX IF NOT EXISTS device_health_history_device_created_idx ON device_health_history (device_id, created_at);`,
+		`CREATE TABLE IF NOT EXISTS device_commands (
+			id SERIAL PRIMARY KEY,
+			device_id INT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+			command VARCHAR(64) NOT NULL,
+			args TEXT NOT NULL DEFAULT '',
+			payload TEXT NOT NULL,
+			sent_by VARCHAR(255) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			error TEXT,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);`,
+		`CREATE INDEX IF NOT EXISTS device_commands_device_created_idx ON device_commands (device_id, created_at);`,
+		`ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS topic VARCHAR(255);`,
+		`ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 1;`,
+		`ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS acknowledged_at TIMESTAMPTZ;`,
+		`CREATE INDEX IF NOT EXISTS device_commands_status_updated_idx ON device_commands (status, updated_at);`,
+		`CREATE TABLE IF NOT EXISTS device_thresholds (
+			id SERIAL PRIMARY KEY,
+			device_id INT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+			metric VARCHAR(64) NOT NULL,
+			min_value DOUBLE PRECISION NOT NULL,
+			max_value DOUBLE PRECISION NOT NULL,
+			command_id INT REFERENCES device_commands(id) ON DELETE SET NULL,
+			synced_at TIMESTAMPTZ,
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE (device_id, metric)
+		);`,
+		`ALTER TABLE devices ADD COLUMN IF NOT EXISTS crop_id INT;`,
+		`CREATE TABLE IF NOT EXISTS crop_thresholds (
+			id SERIAL PRIMARY KEY,
+			crop_id INT NOT NULL,
+			metric VARCHAR(64) NOT NULL,
+			min_value DOUBLE PRECISION NOT NULL,
+			max_value DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			UNIQUE (crop_id, metric)
+		);`,
+		`ALTER TABLE sensor_data ADD COLUMN IF NOT EXISTS device_timestamp TIMESTAMPTZ;`,
+		`CREATE TABLE IF NOT EXISTS dead_letters (
+			id SERIAL PRIMARY KEY,
+			topic VARCHAR(255) NOT NULL,
+			device_type VARCHAR(64) NOT NULL,
+			payload BYTEA NOT NULL,
+			error TEXT NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			reprocessed_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS dead_letters_created_idx ON dead_letters (created_at);`,
+		`CREATE TABLE IF NOT EXISTS raw_messages (
+			id BIGSERIAL PRIMARY KEY,
+			topic VARCHAR(255) NOT NULL,
+			payload BYTEA NOT NULL,
+			qos SMALLINT NOT NULL,
+			received_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);`,
+		`CREATE INDEX IF NOT EXISTS raw_messages_received_idx ON raw_messages (received_at);`,
+		`CREATE TABLE IF NOT EXISTS tank_profiles (
+			id SERIAL PRIMARY KEY,
+			device_id INT NOT NULL UNIQUE REFERENCES devices(id) ON DELETE CASCADE,
+			shape VARCHAR(16) NOT NULL,
+			diameter_cm DOUBLE PRECISION NOT NULL DEFAULT 0,
+			length_cm DOUBLE PRECISION NOT NULL DEFAULT 0,
+			width_cm DOUBLE PRECISION NOT NULL DEFAULT 0,
+			height_cm DOUBLE PRECISION NOT NULL,
+			sensor_offset_cm DOUBLE PRECISION NOT NULL DEFAULT 0,
+			min_level_cm DOUBLE PRECISION NOT NULL DEFAULT 0,
+			max_level_cm DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);`,
+		`ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS redacted BOOLEAN NOT NULL DEFAULT FALSE;`,
+		// Scrub the wifi passwords stored before commands were redacted
+		`UPDATE device_commands
+		SET args = CASE WHEN args LIKE '{%' THEN jsonb_set(args::jsonb, '{password}', '"***"')::text ELSE args END,
+			payload = regexp_replace(payload, '(set_wifi_credentials:[^,;]*),[^;]*', '\1,***'),
+			redacted = TRUE
+		WHERE command = 'set_wifi_credentials';`,
 	}
 
 	// Apply migrations sequentially
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RawMessage is an MQTT message exactly as received, archived before parsing.
+type RawMessage struct {
+	ID         int64     `json:"id"`
+	Topic      string    `json:"topic"`
+	Payload    []byte    `json:"payload"`
+	QoS        byte      `json:"qos"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type RawMessageRepository struct {
+	db *Database
+}
+
+func newRawMessageRepository(db *Database) *RawMessageRepository {
+	return &RawMessageRepository{db: db}
+}
+
+func (r *RawMessageRepository) InsertRawMessage(ctx context.Context, topic string, payload []byte, qos byte, receivedAt time.Time) error {
+	_, err := r.db.pool.Exec(ctx, `
+		INSERT INTO raw_messages
+			(topic, payload, qos, received_at)
+		VALUES
+			($1, $2, $3, $4)
+	`, topic, payload, int16(qos), receivedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert raw message: %w", err)
+	}
+
+	return nil
+}
+
+// GetRawMessages returns up to limit messages received between startTime and
+// endTime with an ID above afterID, oldest first, so callers can page through
+// large ranges.
+func (r *RawMessageRepository) GetRawMessages(ctx context.Context, startTime time.Time, endTime time.Time, afterID int64, limit int) ([]RawMessage, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT id, topic, payload, qos, received_at
+		FROM raw_messages
+		WHERE received_at >= $1 AND received_at <= $2 AND id > $3
+		ORDER BY id ASC
+		LIMIT $4
+	`, startTime, endTime, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query raw messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []RawMessage{}
+	for rows.Next() {
+		var message RawMessage
+		var qos int16
+		if err := rows.Scan(&message.ID, &message.Topic, &message.Payload, &qos, &message.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan raw message: %w", err)
+		}
+		message.QoS = byte(qos)
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over raw messages: %w", err)
+	}
+
+	return messages, nil
+}
@@ -13,6 +13,8 @@ type SensorData struct {
 	Payload        string    `json:"payload"`
 	PayloadVersion int       `json:"payload_version"`
 	CreatedAt      time.Time `json:"created_at"`
+	// DeviceTimestamp is when the device took the reading, if it sent one
+	DeviceTimestamp *time.Time `json:"device_timestamp"`
 }
 
 type SensorRepository struct {
@@ -23,18 +25,20 @@ func newSensorRepository(db *Database) *SensorRepository {
 	return &SensorRepository{db: db}
 }
 
-func (r *SensorRepository) InsertSensorData(ctx context.Context, deviceID int, topicId int, payload string, payloadVersion int) error {
+// InsertSensorData stores a reading received at receivedAt, which is in the
+// past when a dead letter or archived message is reprocessed.
+func (r *SensorRepository) InsertSensorData(ctx context.Context, deviceID int, topicId int, payload string, payloadVersion int, deviceTimestamp *time.Time, receivedAt time.Time) error {
 	_, err := r.db.pool.Exec(ctx, `
 		WITH inserted_data AS (
 			INSERT INTO sensor_data
-				(device_id, topic_id, payload, payload_version)
+				(device_id, topic_id, payload, payload_version, device_timestamp, created_at)
 			VALUES
-				($1, $2, $3, $4)
+				($1, $2, $3, $4, $5, $6)
 		)
 		UPDATE devices
-		SET last_seen = NOW()
+		SET last_seen = GREATEST(last_seen, $6)
 		WHERE id = $1;
-	`, deviceID, topicId, payload, payloadVersion)
+	`, deviceID, topicId, payload, payloadVersion, deviceTimestamp, receivedAt)
 	if err != nil {
 		return fmt.Errorf("failed to insert sensor data: %w", err)
 	}
@@ -71,7 +75,7 @@ func (r *SensorRepository) GetSensorDataByDeviceID(ctx context.Context, deviceID
 
 func (r *SensorRepository) GetSensorDataByDeviceIDWithTimestamp(ctx context.Context, deviceID int, startTime time.Time, endTime time.Time) ([]SensorData, error) {
 	rows, err := r.db.pool.Query(ctx, `
-		SELECT id, device_id, topic_id, payload, payload_version, created_at 
+		SELECT id, device_id, topic_id, payload, payload_version, created_at, device_timestamp
 		FROM sensor_data 
 		WHERE device_id = $1 AND created_at >= $2 AND created_at <= $3
 		ORDER BY created_at ASC
@@ -86,7 +90,7 @@ func (r *SensorRepository) GetSensorDataByDeviceIDWithTimestamp(ctx context.Cont
 	var sensorData []SensorData
 	for rows.Next() {
 		var data SensorData
-		err := rows.Scan(&data.ID, &data.DeviceID, &data.TopicID, &data.Payload, &data.PayloadVersion, &data.CreatedAt)
+		err := rows.Scan(&data.ID, &data.DeviceID, &data.TopicID, &data.Payload, &data.PayloadVersion, &data.CreatedAt, &data.DeviceTimestamp)
 		sensorData = append(sensorData, data)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan sensor data: %w", err)
@@ -95,3 +99,64 @@ func (r *SensorRepository) GetSensorDataByDeviceIDWithTimestamp(ctx context.Cont
 
 	return sensorData, nil
 }
+
+// InsertStoredSensorData stores the reading of a reprocessed message received
+// at receivedAt. A reading the device already has at that exact time came
+// from the same message: with replace it is deleted in the same statement,
+// otherwise it is kept and nothing is inserted. It reports whether such a
+// reading existed.
+func (r *SensorRepository) InsertStoredSensorData(ctx context.Context, deviceID int, topicId int, payload string, payloadVersion int, deviceTimestamp *time.Time, receivedAt time.Time, replace bool) (bool, error) {
+	var existed bool
+	err := r.db.pool.QueryRow(ctx, `
+		WITH existing AS (
+			SELECT id FROM sensor_data
+			WHERE device_id = $1::int AND created_at = $6::timestamptz
+		), deleted_data AS (
+			DELETE FROM sensor_data
+			WHERE $7::boolean AND id IN (SELECT id FROM existing)
+		), inserted_data AS (
+			INSERT INTO sensor_data
+				(device_id, topic_id, payload, payload_version, device_timestamp, created_at)
+			SELECT $1::int, $2::int, $3::varchar, $4::int, $5::timestamptz, $6::timestamptz
+			WHERE $7::boolean OR NOT EXISTS (SELECT 1 FROM existing)
+		), updated_device AS (
+			UPDATE devices
+			SET last_seen = GREATEST(last_seen, $6::timestamptz)
+			WHERE id = $1::int
+		)
+		SELECT EXISTS (SELECT 1 FROM existing);
+	`, deviceID, topicId, payload, payloadVersion, deviceTimestamp, receivedAt, replace).Scan(&existed)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert stored sensor data: %w", err)
+	}
+
+	return existed, nil
+}
+
+// GetLastSensorDataBefore returns the latest reading of a device received
+// before the given time, or nil when there is none.
+func (r *SensorRepository) GetLastSensorDataBefore(ctx context.Context, deviceID int, before time.Time) (*SensorData, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT id, device_id, topic_id, payload, payload_version, created_at, device_timestamp
+		FROM sensor_data
+		WHERE device_id = $1 AND created_at < $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, deviceID, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sensor data: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var data SensorData
+	err = rows.Scan(&data.ID, &data.DeviceID, &data.TopicID, &data.Payload, &data.PayloadVersion, &data.CreatedAt, &data.DeviceTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan sensor data: %w", err)
+	}
+
+	return &data, nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	TankShapeCylinder = "cylinder"
+	TankShapeBox      = "box"
+)
+
+// TankProfile describes the tank measured by a water level meter. Levels are
+// liquid heights above the tank bottom. SensorOffsetCm is added to the
+// reported level, for sensors whose zero is not at the tank bottom.
+type TankProfile struct {
+	ID             int       `json:"id"`
+	DeviceID       int       `json:"device_id"`
+	Shape          string    `json:"shape"`
+	DiameterCm     float64   `json:"diameter_cm"`
+	LengthCm       float64   `json:"length_cm"`
+	WidthCm        float64   `json:"width_cm"`
+	HeightCm       float64   `json:"height_cm"`
+	SensorOffsetCm float64   `json:"sensor_offset_cm"`
+	MinLevelCm     float64   `json:"min_level_cm"`
+	MaxLevelCm     float64   `json:"max_level_cm"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type TankRepository struct {
+	db *Database
+}
+
+func newTankRepository(db *Database) *TankRepository {
+	return &TankRepository{db: db}
+}
+
+func scanTankProfile(row pgx.Row) (*TankProfile, error) {
+	var profile TankProfile
+	err := row.Scan(
+		&profile.ID,
+		&profile.DeviceID,
+		&profile.Shape,
+		&profile.DiameterCm,
+		&profile.LengthCm,
+		&profile.WidthCm,
+		&profile.HeightCm,
+		&profile.SensorOffsetCm,
+		&profile.MinLevelCm,
+		&profile.MaxLevelCm,
+		&profile.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (r *TankRepository) UpsertTankProfile(ctx context.Context, profile TankProfile) (*TankProfile, error) {
+	stored, err := scanTankProfile(r.db.pool.QueryRow(ctx, `
+		INSERT INTO tank_profiles
+			(device_id, shape, diameter_cm, length_cm, width_cm, height_cm, sensor_offset_cm, min_level_cm, max_level_cm)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (device_id) DO UPDATE
+		SET shape = EXCLUDED.shape, diameter_cm = EXCLUDED.diameter_cm, length_cm = EXCLUDED.length_cm,
+			width_cm = EXCLUDED.width_cm, height_cm = EXCLUDED.height_cm, sensor_offset_cm = EXCLUDED.sensor_offset_cm,
+			min_level_cm = EXCLUDED.min_level_cm, max_level_cm = EXCLUDED.max_level_cm, updated_at = NOW()
+		RETURNING id, device_id, shape, diameter_cm, length_cm, width_cm, height_cm, sensor_offset_cm, min_level_cm, max_level_cm, updated_at
+	`, profile.DeviceID, profile.Shape, profile.DiameterCm, profile.LengthCm, profile.WidthCm, profile.HeightCm,
+		profile.SensorOffsetCm, profile.MinLevelCm, profile.MaxLevelCm))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert tank profile: %w", err)
+	}
+
+	return stored, nil
+}
+
+// GetTankProfileByDeviceID returns nil when the device has no tank profile.
+func (r *TankRepository) GetTankProfileByDeviceID(ctx context.Context, deviceID int) (*TankProfile, error) {
+	profile, err := scanTankProfile(r.db.pool.QueryRow(ctx, `
+		SELECT id, device_id, shape, diameter_cm, length_cm, width_cm, height_cm, sensor_offset_cm, min_level_cm, max_level_cm, updated_at
+		FROM tank_profiles
+		WHERE device_id = $1
+	`, deviceID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tank profile: %w", err)
+	}
+
+	return profile, nil
+}
+
+func (r *TankRepository) DeleteTankProfile(ctx context.Context, deviceID int) (bool, error) {
+	tag, err := r.db.pool.Exec(ctx, `
+		DELETE FROM tank_profiles
+		WHERE device_id = $1
+	`, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete tank profile: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
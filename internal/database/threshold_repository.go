@@ -0,0 +1,245 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type DeviceThreshold struct {
+	ID        int        `json:"id"`
+	DeviceID  int        `json:"device_id"`
+	Metric    string     `json:"metric"`
+	Min       float64    `json:"min"`
+	Max       float64    `json:"max"`
+	CommandID *int       `json:"command_id"`
+	SyncedAt  *time.Time `json:"synced_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type ThresholdRepository struct {
+	db *Database
+}
+
+func newThresholdRepository(db *Database) *ThresholdRepository {
+	return &ThresholdRepository{db: db}
+}
+
+func scanDeviceThreshold(row pgx.Row) (*DeviceThreshold, error) {
+	var threshold DeviceThreshold
+	err := row.Scan(
+		&threshold.ID,
+		&threshold.DeviceID,
+		&threshold.Metric,
+		&threshold.Min,
+		&threshold.Max,
+		&threshold.CommandID,
+		&threshold.SyncedAt,
+		&threshold.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &threshold, nil
+}
+
+// UpsertThreshold stores the threshold of a metric, clearing its sync state
+// so it is pushed to the device again.
+func (r *ThresholdRepository) UpsertThreshold(ctx context.Context, deviceID int, metric string, min, max float64) (*DeviceThreshold, error) {
+	threshold, err := scanDeviceThreshold(r.db.pool.QueryRow(ctx, `
+		INSERT INTO device_thresholds
+			(device_id, metric, min_value, max_value)
+		VALUES
+			($1, $2, $3, $4)
+		ON CONFLICT (device_id, metric) DO UPDATE
+		SET min_value = EXCLUDED.min_value, max_value = EXCLUDED.max_value,
+			command_id = NULL, synced_at = NULL, updated_at = NOW()
+		RETURNING id, device_id, metric, min_value, max_value, command_id, synced_at, updated_at
+	`, deviceID, metric, min, max))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert device threshold: %w", err)
+	}
+
+	return threshold, nil
+}
+
+func (r *ThresholdRepository) GetThresholdsByDeviceID(ctx context.Context, deviceID int) ([]DeviceThreshold, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT id, device_id, metric, min_value, max_value, command_id, synced_at, updated_at
+		FROM device_thresholds
+		WHERE device_id = $1
+		ORDER BY metric
+	`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device thresholds: %w", err)
+	}
+	defer rows.Close()
+
+	thresholds := []DeviceThreshold{}
+	for rows.Next() {
+		threshold, err := scanDeviceThreshold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device threshold: %w", err)
+		}
+		thresholds = append(thresholds, *threshold)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over device thresholds: %w", err)
+	}
+
+	return thresholds, nil
+}
+
+func (r *ThresholdRepository) DeleteThreshold(ctx context.Context, deviceID int, metric string) (bool, error) {
+	tag, err := r.db.pool.Exec(ctx, `
+		DELETE FROM device_thresholds
+		WHERE device_id = $1 AND metric = $2
+	`, deviceID, metric)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete device threshold: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *ThresholdRepository) MarkThresholdSynced(ctx context.Context, thresholdID int, commandID int) error {
+	_, err := r.db.pool.Exec(ctx, `
+		UPDATE device_thresholds
+		SET command_id = $2, synced_at = NOW()
+		WHERE id = $1
+	`, thresholdID, commandID)
+	if err != nil {
+		return fmt.Errorf("failed to mark device threshold %d as synced: %w", thresholdID, err)
+	}
+
+	return nil
+}
+
+type CropThreshold struct {
+	ID        int       `json:"id"`
+	CropID    int       `json:"crop_id"`
+	Metric    string    `json:"metric"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Threshold is the range applied to a metric of a device, coming from the
+// device itself or from its crop.
+type Threshold struct {
+	Metric string  `json:"metric"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Source string  `json:"source"`
+}
+
+const (
+	ThresholdSourceDevice = "device"
+	ThresholdSourceCrop   = "crop"
+)
+
+func (r *ThresholdRepository) UpsertCropThreshold(ctx context.Context, cropID int, metric string, min, max float64) (*CropThreshold, error) {
+	var threshold CropThreshold
+	err := r.db.pool.QueryRow(ctx, `
+		INSERT INTO crop_thresholds
+			(crop_id, metric, min_value, max_value)
+		VALUES
+			($1, $2, $3, $4)
+		ON CONFLICT (crop_id, metric) DO UPDATE
+		SET min_value = EXCLUDED.min_value, max_value = EXCLUDED.max_value, updated_at = NOW()
+		RETURNING id, crop_id, metric, min_value, max_value, updated_at
+	`, cropID, metric, min, max).Scan(
+		&threshold.ID,
+		&threshold.CropID,
+		&threshold.Metric,
+		&threshold.Min,
+		&threshold.Max,
+		&threshold.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert crop threshold: %w", err)
+	}
+
+	return &threshold, nil
+}
+
+func (r *ThresholdRepository) GetCropThresholds(ctx context.Context, cropID int) ([]CropThreshold, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT id, crop_id, metric, min_value, max_value, updated_at
+		FROM crop_thresholds
+		WHERE crop_id = $1
+		ORDER BY metric
+	`, cropID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crop thresholds: %w", err)
+	}
+	defer rows.Close()
+
+	thresholds := []CropThreshold{}
+	for rows.Next() {
+		var threshold CropThreshold
+		if err := rows.Scan(&threshold.ID, &threshold.CropID, &threshold.Metric, &threshold.Min, &threshold.Max, &threshold.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan crop threshold: %w", err)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over crop thresholds: %w", err)
+	}
+
+	return thresholds, nil
+}
+
+func (r *ThresholdRepository) DeleteCropThreshold(ctx context.Context, cropID int, metric string) (bool, error) {
+	tag, err := r.db.pool.Exec(ctx, `
+		DELETE FROM crop_thresholds
+		WHERE crop_id = $1 AND metric = $2
+	`, cropID, metric)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete crop threshold: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetEffectiveThresholds returns the thresholds applied to the device, where
+// a device threshold overrides the threshold of its crop for the same metric.
+func (r *ThresholdRepository) GetEffectiveThresholds(ctx context.Context, deviceID int) ([]Threshold, error) {
+	rows, err := r.db.pool.Query(ctx, `
+		SELECT DISTINCT ON (metric) metric, min_value, max_value, source
+		FROM (
+			SELECT metric, min_value, max_value, $2::text AS source, 0 AS priority
+			FROM device_thresholds
+			WHERE device_id = $1
+			UNION ALL
+			SELECT ct.metric, ct.min_value, ct.max_value, $3::text AS source, 1 AS priority
+			FROM crop_thresholds ct
+			JOIN devices d ON d.crop_id = ct.crop_id
+			WHERE d.id = $1
+		) thresholds
+		ORDER BY metric, priority
+	`, deviceID, ThresholdSourceDevice, ThresholdSourceCrop)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query effective thresholds: %w", err)
+	}
+	defer rows.Close()
+
+	thresholds := []Threshold{}
+	for rows.Next() {
+		var threshold Threshold
+		if err := rows.Scan(&threshold.Metric, &threshold.Min, &threshold.Max, &threshold.Source); err != nil {
+			return nil, fmt.Errorf("failed to scan effective threshold: %w", err)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over effective thresholds: %w", err)
+	}
+
+	return thresholds, nil
+}
@@ -0,0 +1,127 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+const (
+	defaultCommandHistoryLimit = 50
+	maxCommandHistoryLimit     = 500
+)
+
+type CommandEndpoints struct {
+	db       *database.Database
+	registry *workers.Registry
+}
+
+type SendCommandRequest struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args"`
+	SentBy  string          `json:"sent_by"`
+}
+
+func NewCommandEndpoints(db *database.Database, registry *workers.Registry) *CommandEndpoints {
+	return &CommandEndpoints{db: db, registry: registry}
+}
+
+func (ce *CommandEndpoints) SendCommand(rw http.ResponseWriter, r *http.Request) {
+	var request SendCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(rw, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Command == "" {
+		http.Error(rw, "No command provided", http.StatusBadRequest)
+		return
+	}
+
+	sentBy := requestSender(r, request.SentBy)
+
+	device, err := ce.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	command, err := ce.registry.SendCommand(r.Context(), device, request.Command, request.Args, sentBy)
+	if err != nil {
+		fmt.Println("Error sending command:", err)
+		switch {
+		case errors.Is(err, workers.ErrInvalidCommand), errors.Is(err, workers.ErrCommandsNotSupported):
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		case command == nil:
+			http.Error(rw, "Failed to send command", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	jsonBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(rw, "Failed to marshal command", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if command.Status == database.CommandStatusFailed {
+		rw.WriteHeader(http.StatusBadGateway)
+	} else {
+		rw.WriteHeader(http.StatusAccepted)
+	}
+	rw.Write(jsonBytes)
+}
+
+func (ce *CommandEndpoints) GetCommands(rw http.ResponseWriter, r *http.Request) {
+	limit := defaultCommandHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxCommandHistoryLimit {
+			http.Error(rw, fmt.Sprintf("Invalid limit. Use a number between 1 and %d", maxCommandHistoryLimit), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	device, err := ce.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	commands, err := ce.db.CommandRepository().GetCommandsByDeviceID(r.Context(), device.ID, limit)
+	if err != nil {
+		fmt.Println("Error fetching device commands:", err)
+		http.Error(rw, "Failed to get device commands", http.StatusInternalServerError)
+		return
+	}
+	ce.registry.RedactCommands(device, commands)
+
+	jsonBytes, err := json.Marshal(commands)
+	if err != nil {
+		http.Error(rw, "Failed to marshal device commands", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+// requestSender falls back to the X-User header set by the reverse proxy and
+// finally to the remote address when the body names no sender.
+func requestSender(r *http.Request, sentBy string) string {
+	if sentBy == "" {
+		sentBy = r.Header.Get("X-User")
+	}
+	if sentBy == "" {
+		sentBy = r.RemoteAddr
+	}
+	return sentBy
+}
@@ -0,0 +1,141 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+const (
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+type DeadLetterEndpoints struct {
+	db       *database.Database
+	registry *workers.Registry
+}
+
+type ReprocessResult struct {
+	ID    int    `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+func NewDeadLetterEndpoints(db *database.Database, registry *workers.Registry) *DeadLetterEndpoints {
+	return &DeadLetterEndpoints{db: db, registry: registry}
+}
+
+func parseDeadLetterLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultDeadLetterLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxDeadLetterLimit {
+		return 0, fmt.Errorf("Invalid limit. Use a number between 1 and %d", maxDeadLetterLimit)
+	}
+	return limit, nil
+}
+
+// GetDeadLetters lists the newest dead letters. Reprocessed ones are only
+// included with ?all=true.
+func (de *DeadLetterEndpoints) GetDeadLetters(rw http.ResponseWriter, r *http.Request) {
+	limit, err := parseDeadLetterLimit(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pendingOnly := r.URL.Query().Get("all") != "true"
+	deadLetters, err := de.db.DeadLetterRepository().GetDeadLetters(r.Context(), pendingOnly, limit)
+	if err != nil {
+		fmt.Println("Error fetching dead letters:", err)
+		http.Error(rw, "Failed to get dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(deadLetters)
+	if err != nil {
+		http.Error(rw, "Failed to marshal dead letters", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+func (de *DeadLetterEndpoints) ReprocessDeadLetter(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(rw, "Invalid dead letter ID", http.StatusBadRequest)
+		return
+	}
+
+	deadLetter, err := de.db.DeadLetterRepository().GetDeadLetterByID(r.Context(), id)
+	if err != nil {
+		http.Error(rw, "Failed to get dead letter", http.StatusNotFound)
+		return
+	}
+
+	if deadLetter.ReprocessedAt != nil {
+		http.Error(rw, "Dead letter was already reprocessed", http.StatusConflict)
+		return
+	}
+
+	result := ReprocessResult{ID: id}
+	status := http.StatusOK
+	if err := de.registry.ReprocessDeadLetter(r.Context(), deadLetter); err != nil {
+		result.Error = err.Error()
+		status = http.StatusUnprocessableEntity
+	}
+
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		http.Error(rw, "Failed to marshal reprocess result", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(jsonBytes)
+}
+
+// ReprocessDeadLetters reprocesses the oldest pending dead letters up to the
+// limit, reporting the outcome of each one.
+func (de *DeadLetterEndpoints) ReprocessDeadLetters(rw http.ResponseWriter, r *http.Request) {
+	limit, err := parseDeadLetterLimit(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deadLetters, err := de.db.DeadLetterRepository().GetDeadLetters(r.Context(), true, limit)
+	if err != nil {
+		fmt.Println("Error fetching dead letters:", err)
+		http.Error(rw, "Failed to get dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]ReprocessResult, 0, len(deadLetters))
+	// Oldest first so readings are stored in the order they were received
+	for i := len(deadLetters) - 1; i >= 0; i-- {
+		result := ReprocessResult{ID: deadLetters[i].ID}
+		if err := de.registry.ReprocessDeadLetter(r.Context(), &deadLetters[i]); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	jsonBytes, err := json.Marshal(results)
+	if err != nil {
+		http.Error(rw, "Failed to marshal reprocess results", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
@@ -0,0 +1,125 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+type EnergyEndpoints struct {
+	db       *database.Database
+	registry *workers.Registry
+}
+
+type EnergyUsageResponse struct {
+	Period   workers.EnergyPeriod  `json:"period"`
+	TotalKWh float64               `json:"total_kwh"`
+	Usage    []workers.EnergyUsage `json:"usage"`
+}
+
+func NewEnergyEndpoints(db *database.Database, registry *workers.Registry) *EnergyEndpoints {
+	return &EnergyEndpoints{db: db, registry: registry}
+}
+
+// GetEnergyUsage returns the energy consumed per day or month between start and
+// end. Periods use the tz query parameter, UTC by default.
+func (ee *EnergyEndpoints) GetEnergyUsage(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	periodParam := query.Get("period")
+	if periodParam == "" {
+		periodParam = string(workers.EnergyPeriodDay)
+	}
+	period, err := workers.ParseEnergyPeriod(periodParam)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	location := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			http.Error(rw, "Invalid tz, use an IANA time zone name", http.StatusBadRequest)
+			return
+		}
+	}
+
+	startTime, err := time.Parse(time.RFC3339, query.Get("start"))
+	if err != nil {
+		http.Error(rw, "Invalid start time format. Use ISO 8601 format", http.StatusBadRequest)
+		return
+	}
+
+	endTime := time.Now()
+	if end := query.Get("end"); end != "" {
+		endTime, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			http.Error(rw, "Invalid end time format. Use ISO 8601 format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !startTime.Before(endTime) {
+		http.Error(rw, "Start time must be before end time", http.StatusBadRequest)
+		return
+	}
+
+	device, err := ee.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	worker, exists := ee.registry.ByDeviceType(device.Type)
+	energyWorker, ok := worker.(workers.EnergyWorker)
+	if !exists || !ok {
+		http.Error(rw, fmt.Sprintf("Device type %s does not report energy", device.Type), http.StatusBadRequest)
+		return
+	}
+
+	sr := ee.db.SensorRepository()
+	rows, err := sr.GetSensorDataByDeviceIDWithTimestamp(r.Context(), device.ID, startTime, endTime)
+	if err != nil {
+		fmt.Println("Error fetching sensor data:", err)
+		http.Error(rw, "Failed to get sensor data", http.StatusInternalServerError)
+		return
+	}
+
+	// The reading before the range is the starting counter, so the energy
+	// consumed up to the first reading of the range is counted
+	previous, err := sr.GetLastSensorDataBefore(r.Context(), device.ID, startTime)
+	if err != nil {
+		fmt.Println("Error fetching sensor data:", err)
+		http.Error(rw, "Failed to get sensor data", http.StatusInternalServerError)
+		return
+	}
+	if previous != nil {
+		rows = append([]database.SensorData{*previous}, rows...)
+	}
+
+	usage, err := energyWorker.EnergyUsage(rows, period, location)
+	if err != nil {
+		http.Error(rw, "Failed to aggregate energy usage", http.StatusInternalServerError)
+		return
+	}
+
+	response := EnergyUsageResponse{Period: period, Usage: usage}
+	for _, entry := range usage {
+		response.TotalKWh += entry.EnergyKWh
+	}
+
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		http.Error(rw, "Failed to marshal energy usage", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
@@ -0,0 +1,142 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+type TankEndpoints struct {
+	db       *database.Database
+	registry *workers.Registry
+}
+
+type PutTankProfileRequest struct {
+	Shape          string  `json:"shape"`
+	DiameterCm     float64 `json:"diameter_cm"`
+	LengthCm       float64 `json:"length_cm"`
+	WidthCm        float64 `json:"width_cm"`
+	HeightCm       float64 `json:"height_cm"`
+	SensorOffsetCm float64 `json:"sensor_offset_cm"`
+	MinLevelCm     float64 `json:"min_level_cm"`
+	// MaxLevelCm defaults to the tank height
+	MaxLevelCm *float64 `json:"max_level_cm"`
+}
+
+func NewTankEndpoints(db *database.Database, registry *workers.Registry) *TankEndpoints {
+	return &TankEndpoints{db: db, registry: registry}
+}
+
+func (te *TankEndpoints) GetTankProfile(rw http.ResponseWriter, r *http.Request) {
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := te.db.TankRepository().GetTankProfileByDeviceID(r.Context(), device.ID)
+	if err != nil {
+		fmt.Println("Error fetching tank profile:", err)
+		http.Error(rw, "Failed to get tank profile", http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		http.Error(rw, "Tank profile not found", http.StatusNotFound)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(profile)
+	if err != nil {
+		http.Error(rw, "Failed to marshal tank profile", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+func (te *TankEndpoints) PutTankProfile(rw http.ResponseWriter, r *http.Request) {
+	var request PutTankProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(rw, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	worker, exists := te.registry.ByDeviceType(device.Type)
+	if _, ok := worker.(workers.TankWorker); !exists || !ok {
+		http.Error(rw, fmt.Sprintf("Device type %s does not measure a tank", device.Type), http.StatusBadRequest)
+		return
+	}
+
+	profile := database.TankProfile{
+		DeviceID:       device.ID,
+		Shape:          request.Shape,
+		DiameterCm:     request.DiameterCm,
+		LengthCm:       request.LengthCm,
+		WidthCm:        request.WidthCm,
+		HeightCm:       request.HeightCm,
+		SensorOffsetCm: request.SensorOffsetCm,
+		MinLevelCm:     request.MinLevelCm,
+		MaxLevelCm:     request.HeightCm,
+	}
+	if request.MaxLevelCm != nil {
+		profile.MaxLevelCm = *request.MaxLevelCm
+	}
+
+	if err := workers.ValidateTankProfile(profile); err != nil {
+		if errors.Is(err, workers.ErrInvalidTankProfile) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(rw, "Failed to validate tank profile", http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := te.db.TankRepository().UpsertTankProfile(r.Context(), profile)
+	if err != nil {
+		fmt.Println("Error storing tank profile:", err)
+		http.Error(rw, "Failed to store tank profile", http.StatusInternalServerError)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(stored)
+	if err != nil {
+		http.Error(rw, "Failed to marshal tank profile", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+func (te *TankEndpoints) DeleteTankProfile(rw http.ResponseWriter, r *http.Request) {
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := te.db.TankRepository().DeleteTankProfile(r.Context(), device.ID)
+	if err != nil {
+		fmt.Println("Error deleting tank profile:", err)
+		http.Error(rw, "Failed to delete tank profile", http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		http.Error(rw, "Tank profile not found", http.StatusNotFound)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,282 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+type ThresholdEndpoints struct {
+	db       *database.Database
+	registry *workers.Registry
+}
+
+type PutThresholdRequest struct {
+	Min    *float64 `json:"min"`
+	Max    *float64 `json:"max"`
+	SentBy string   `json:"sent_by"`
+}
+
+type PutThresholdResponse struct {
+	Threshold *database.DeviceThreshold `json:"threshold"`
+	Command   *database.DeviceCommand   `json:"command"`
+}
+
+func NewThresholdEndpoints(db *database.Database, registry *workers.Registry) *ThresholdEndpoints {
+	return &ThresholdEndpoints{db: db, registry: registry}
+}
+
+func (te *ThresholdEndpoints) GetThresholds(rw http.ResponseWriter, r *http.Request) {
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	thresholds, err := te.db.ThresholdRepository().GetThresholdsByDeviceID(r.Context(), device.ID)
+	if err != nil {
+		fmt.Println("Error fetching device thresholds:", err)
+		http.Error(rw, "Failed to get device thresholds", http.StatusInternalServerError)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(thresholds)
+	if err != nil {
+		http.Error(rw, "Failed to marshal device thresholds", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+// PutThreshold stores the threshold of a metric and pushes it to the device
+// right away. A failed push is retried the next time the device reconnects.
+func (te *ThresholdEndpoints) PutThreshold(rw http.ResponseWriter, r *http.Request) {
+	var request PutThresholdRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(rw, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Min == nil || request.Max == nil {
+		http.Error(rw, "Both min and max must be provided", http.StatusBadRequest)
+		return
+	}
+
+	sentBy := requestSender(r, request.SentBy)
+
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	metric := r.PathValue("metric")
+	err = te.registry.ValidateThreshold(device, metric, *request.Min, *request.Max)
+	if err != nil {
+		if errors.Is(err, workers.ErrInvalidCommand) || errors.Is(err, workers.ErrCommandsNotSupported) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(rw, "Failed to validate threshold", http.StatusInternalServerError)
+		return
+	}
+
+	threshold, err := te.db.ThresholdRepository().UpsertThreshold(r.Context(), device.ID, metric, *request.Min, *request.Max)
+	if err != nil {
+		fmt.Println("Error storing device threshold:", err)
+		http.Error(rw, "Failed to store device threshold", http.StatusInternalServerError)
+		return
+	}
+
+	command, err := te.registry.PushThreshold(r.Context(), device, threshold, sentBy)
+	if err != nil {
+		fmt.Println("Error pushing device threshold:", err)
+	}
+
+	jsonBytes, err := json.Marshal(PutThresholdResponse{Threshold: threshold, Command: command})
+	if err != nil {
+		http.Error(rw, "Failed to marshal device threshold", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+// DeleteThreshold stops managing the threshold of a metric. The device keeps
+// the value it last received.
+func (te *ThresholdEndpoints) DeleteThreshold(rw http.ResponseWriter, r *http.Request) {
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := te.db.ThresholdRepository().DeleteThreshold(r.Context(), device.ID, r.PathValue("metric"))
+	if err != nil {
+		fmt.Println("Error deleting device threshold:", err)
+		http.Error(rw, "Failed to delete device threshold", http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		http.Error(rw, "Threshold not found", http.StatusNotFound)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+type PutDeviceCropRequest struct {
+	CropID *int `json:"crop_id"`
+}
+
+// GetEffectiveThresholds returns the thresholds the server grades the device
+// readings with, merging the device and crop thresholds.
+func (te *ThresholdEndpoints) GetEffectiveThresholds(rw http.ResponseWriter, r *http.Request) {
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	thresholds, err := te.db.ThresholdRepository().GetEffectiveThresholds(r.Context(), device.ID)
+	if err != nil {
+		fmt.Println("Error fetching effective thresholds:", err)
+		http.Error(rw, "Failed to get effective thresholds", http.StatusInternalServerError)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(thresholds)
+	if err != nil {
+		http.Error(rw, "Failed to marshal effective thresholds", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+func (te *ThresholdEndpoints) PutDeviceCrop(rw http.ResponseWriter, r *http.Request) {
+	var request PutDeviceCropRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(rw, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	device, err := te.db.DeviceRepository().GetDeviceByFuseID(r.Context(), r.PathValue("fuse_id"))
+	if err != nil {
+		http.Error(rw, "Failed to get device by fuse ID", http.StatusInternalServerError)
+		return
+	}
+
+	err = te.db.DeviceRepository().SetDeviceCrop(r.Context(), device.ID, request.CropID)
+	if err != nil {
+		fmt.Println("Error setting device crop:", err)
+		http.Error(rw, "Failed to set device crop", http.StatusInternalServerError)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (te *ThresholdEndpoints) GetCropThresholds(rw http.ResponseWriter, r *http.Request) {
+	cropID, err := strconv.Atoi(r.PathValue("crop_id"))
+	if err != nil {
+		http.Error(rw, "Invalid crop ID", http.StatusBadRequest)
+		return
+	}
+
+	thresholds, err := te.db.ThresholdRepository().GetCropThresholds(r.Context(), cropID)
+	if err != nil {
+		fmt.Println("Error fetching crop thresholds:", err)
+		http.Error(rw, "Failed to get crop thresholds", http.StatusInternalServerError)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(thresholds)
+	if err != nil {
+		http.Error(rw, "Failed to marshal crop thresholds", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+// PutCropThreshold stores the threshold of a metric for every device of the
+// crop. Crop thresholds are only used for the server severities and are not
+// pushed to the devices.
+func (te *ThresholdEndpoints) PutCropThreshold(rw http.ResponseWriter, r *http.Request) {
+	cropID, err := strconv.Atoi(r.PathValue("crop_id"))
+	if err != nil {
+		http.Error(rw, "Invalid crop ID", http.StatusBadRequest)
+		return
+	}
+
+	var request PutThresholdRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(rw, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Min == nil || request.Max == nil {
+		http.Error(rw, "Both min and max must be provided", http.StatusBadRequest)
+		return
+	}
+
+	if *request.Min >= *request.Max {
+		http.Error(rw, "Min must be lower than max", http.StatusBadRequest)
+		return
+	}
+
+	if err := te.registry.ValidateSeverityMetric(r.PathValue("metric")); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	threshold, err := te.db.ThresholdRepository().UpsertCropThreshold(r.Context(), cropID, r.PathValue("metric"), *request.Min, *request.Max)
+	if err != nil {
+		fmt.Println("Error storing crop threshold:", err)
+		http.Error(rw, "Failed to store crop threshold", http.StatusInternalServerError)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(threshold)
+	if err != nil {
+		http.Error(rw, "Failed to marshal crop threshold", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(jsonBytes)
+}
+
+func (te *ThresholdEndpoints) DeleteCropThreshold(rw http.ResponseWriter, r *http.Request) {
+	cropID, err := strconv.Atoi(r.PathValue("crop_id"))
+	if err != nil {
+		http.Error(rw, "Invalid crop ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := te.db.ThresholdRepository().DeleteCropThreshold(r.Context(), cropID, r.PathValue("metric"))
+	if err != nil {
+		fmt.Println("Error deleting crop threshold:", err)
+		http.Error(rw, "Failed to delete crop threshold", http.StatusInternalServerError)
+		return
+	}
+
+	if !deleted {
+		http.Error(rw, "Threshold not found", http.StatusNotFound)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -6,21 +6,50 @@ import (
 
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/http/endpoints"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
 )
 
 type Server struct {
-	Port            int
-	sensorsEndpoint *endpoints.SensorEndpoints
+	Port                int
+	sensorsEndpoint     *endpoints.SensorEndpoints
+	commandsEndpoint    *endpoints.CommandEndpoints
+	thresholdsEndpoint  *endpoints.ThresholdEndpoints
+	deadLettersEndpoint *endpoints.DeadLetterEndpoints
+	energyEndpoint      *endpoints.EnergyEndpoints
+	tanksEndpoint       *endpoints.TankEndpoints
 }
 
-func NewServer(port int, database *database.Database) *Server {
+func NewServer(port int, database *database.Database, registry *workers.Registry) *Server {
 	server := &Server{
-		Port:            port,
-		sensorsEndpoint: endpoints.NewSensorEndpoints(database),
+		Port:                port,
+		sensorsEndpoint:     endpoints.NewSensorEndpoints(database, registry),
+		commandsEndpoint:    endpoints.NewCommandEndpoints(database, registry),
+		thresholdsEndpoint:  endpoints.NewThresholdEndpoints(database, registry),
+		deadLettersEndpoint: endpoints.NewDeadLetterEndpoints(database, registry),
+		energyEndpoint:      endpoints.NewEnergyEndpoints(database, registry),
+		tanksEndpoint:       endpoints.NewTankEndpoints(database, registry),
 	}
 
 	http.HandleFunc("/sensors", server.sensorsEndpoint.GetSensorsByID)
 	http.HandleFunc("/sensor/data", server.sensorsEndpoint.GetSensorDataByIDAndTimestamp)
+	http.HandleFunc("/sensor/health", server.sensorsEndpoint.GetDeviceHealthByIDAndTimestamp)
+	http.HandleFunc("POST /devices/{fuse_id}/commands", server.commandsEndpoint.SendCommand)
+	http.HandleFunc("GET /devices/{fuse_id}/commands", server.commandsEndpoint.GetCommands)
+	http.HandleFunc("GET /devices/{fuse_id}/thresholds", server.thresholdsEndpoint.GetThresholds)
+	http.HandleFunc("PUT /devices/{fuse_id}/thresholds/{metric}", server.thresholdsEndpoint.PutThreshold)
+	http.HandleFunc("DELETE /devices/{fuse_id}/thresholds/{metric}", server.thresholdsEndpoint.DeleteThreshold)
+	http.HandleFunc("GET /devices/{fuse_id}/thresholds/effective", server.thresholdsEndpoint.GetEffectiveThresholds)
+	http.HandleFunc("PUT /devices/{fuse_id}/crop", server.thresholdsEndpoint.PutDeviceCrop)
+	http.HandleFunc("GET /devices/{fuse_id}/energy", server.energyEndpoint.GetEnergyUsage)
+	http.HandleFunc("GET /devices/{fuse_id}/tank", server.tanksEndpoint.GetTankProfile)
+	http.HandleFunc("PUT /devices/{fuse_id}/tank", server.tanksEndpoint.PutTankProfile)
+	http.HandleFunc("DELETE /devices/{fuse_id}/tank", server.tanksEndpoint.DeleteTankProfile)
+	http.HandleFunc("GET /crops/{crop_id}/thresholds", server.thresholdsEndpoint.GetCropThresholds)
+	http.HandleFunc("PUT /crops/{crop_id}/thresholds/{metric}", server.thresholdsEndpoint.PutCropThreshold)
+	http.HandleFunc("DELETE /crops/{crop_id}/thresholds/{metric}", server.thresholdsEndpoint.DeleteCropThreshold)
+	http.HandleFunc("GET /admin/dead-letters", server.deadLettersEndpoint.GetDeadLetters)
+	http.HandleFunc("POST /admin/dead-letters/reprocess", server.deadLettersEndpoint.ReprocessDeadLetters)
+	http.HandleFunc("POST /admin/dead-letters/{id}/reprocess", server.deadLettersEndpoint.ReprocessDeadLetter)
 	go func() {
 		http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
 	}()
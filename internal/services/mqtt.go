@@ -1,10 +1,15 @@
 package services
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -15,97 +20,500 @@ type MQTTConfig struct {
 	ClientCrtFilePath string
 	ClientKeyFilePath string
 	ClientId          string
-	BrokerUrl         string
+	// BrokerUrl selects the transport through its scheme: tcp:// or mqtt://
+	// for plain TCP, ws:// for WebSocket and mqtts://, ssl://, tls:// or wss://
+	// for their TLS counterparts.
+	BrokerUrl      string
+	Username       string
+	Password       string
+	PublishTimeout time.Duration
+	// StatusTopic receives a retained "online" birth message on every connect
+	// and the "offline" Last Will when the worker disappears.
+	StatusTopic string
+
+	// TLSServerName overrides the host name used to verify the broker
+	// certificate, for certificates whose SAN does not match the broker URL.
+	TLSServerName         string
+	TLSMinVersion         uint16
+	TLSInsecureSkipVerify bool
+
+	Pipeline PipelineConfig
+
+	// PersistentSession connects with CleanSession=false so the broker queues
+	// QoS 1/2 messages while the worker is offline. It requires a stable
+	// ClientId. In-flight messages are kept in StoreDirectory across restarts.
+	PersistentSession bool
+	StoreDirectory    string
+	DefaultQoS        byte
+
+	// SharedSubscriptionGroup subscribes through $share/<group>/<topic> so
+	// worker replicas in the same group split the messages between them.
+	SharedSubscriptionGroup string
+
+	// ProtocolVersion selects MQTT 3.1 (3), 3.1.1 (4, the default) or 5. Only
+	// MQTT 5 carries the properties used by Request.
+	ProtocolVersion uint
+}
+
+const DefaultPublishTimeout = 5 * time.Second
+
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+func DefaultStatusTopic(clientId string) string {
+	return fmt.Sprintf("mqtt-home-server-worker/%s/status", clientId)
 }
 
 type MqttMessageHandler func(msg mqtt.Message)
 
+// MessageProperties is implemented by messages received over MQTT 5.
+type MessageProperties interface {
+	ResponseTopic() string
+	CorrelationData() []byte
+	UserProperties() map[string]string
+}
+
+// OutgoingMessage describes a publish. ResponseTopic, CorrelationData and
+// UserProperties are only supported by the MQTT 5 transport.
+type OutgoingMessage struct {
+	Topic           string
+	Payload         []byte
+	QoS             byte
+	Retained        bool
+	ResponseTopic   string
+	CorrelationData []byte
+	UserProperties  map[string]string
+}
+
+func (m OutgoingMessage) hasProperties() bool {
+	return m.ResponseTopic != "" || len(m.CorrelationData) > 0 || len(m.UserProperties) > 0
+}
+
+// mqttTransport hides the protocol specific client (paho.mqtt.golang for MQTT
+// 3.1.1, paho.golang for MQTT 5) behind the MQTTClient abstraction.
+type mqttTransport interface {
+	Connect() error
+	IsConnected() bool
+	IsConnectionOpen() bool
+	Subscribe(filter string, qos byte) error
+	Unsubscribe(filter string) error
+	Publish(ctx context.Context, msg OutgoingMessage) error
+	Disconnect()
+}
+
+type subscription struct {
+	qos     byte
+	group   string
+	handler MqttMessageHandler
+}
+
 type MQTTClient struct {
-	config   MQTTConfig
-	client   mqtt.Client
-	handlers map[string]MqttMessageHandler
+	config     MQTTConfig
+	transport  mqttTransport
+	handlers   map[string]subscription
+	handlersMu sync.RWMutex
+	pipeline   *MessagePipeline
+
+	connects         atomic.Int64
+	connectionLosses atomic.Int64
+
+	requests   map[string]chan mqtt.Message
+	requestsMu sync.Mutex
+
+	connectListeners   []func()
+	connectListenersMu sync.Mutex
+}
+
+type MQTTConnectionStats struct {
+	Connects         int64 `json:"connects"`
+	Reconnects       int64 `json:"reconnects"`
+	ConnectionLosses int64 `json:"connection_losses"`
 }
 
 func NewMQTTClient(config MQTTConfig) *MQTTClient {
 	return &MQTTClient{
 		config:   config,
-		handlers: make(map[string]MqttMessageHandler),
+		handlers: make(map[string]subscription),
+		pipeline: NewMessagePipeline(config.Pipeline),
+		requests: make(map[string]chan mqtt.Message),
 	}
 }
 
+func (worker *MQTTClient) ClientId() string {
+	return worker.config.ClientId
+}
+
+func (worker *MQTTClient) StatusTopic() string {
+	if worker.config.StatusTopic == "" {
+		return DefaultStatusTopic(worker.config.ClientId)
+	}
+	return worker.config.StatusTopic
+}
+
+func (worker *MQTTClient) Stats() MQTTConnectionStats {
+	connects := worker.connects.Load()
+	return MQTTConnectionStats{
+		Connects:         connects,
+		Reconnects:       max(connects-1, 0),
+		ConnectionLosses: worker.connectionLosses.Load(),
+	}
+}
+
+func (worker *MQTTClient) PipelineStats() PipelineStats {
+	return worker.pipeline.Stats()
+}
+
+// OnConnect registers a listener called after the initial connection and
+// every reconnect, once the subscriptions are restored.
+func (worker *MQTTClient) OnConnect(listener func()) {
+	worker.connectListenersMu.Lock()
+	defer worker.connectListenersMu.Unlock()
+	worker.connectListeners = append(worker.connectListeners, listener)
+}
+
+func (worker *MQTTClient) IsV5() bool {
+	return worker.config.ProtocolVersion == 5
+}
+
 func (worker *MQTTClient) IsRunning() bool {
-	return worker.client.IsConnected()
+	return worker.transport != nil && worker.transport.IsConnected()
 }
 
 func (worker *MQTTClient) Start() error {
 	config := worker.config
 
-	caCert, err := os.ReadFile(config.CaFilePath)
+	secure, err := isSecureBrokerUrl(config.BrokerUrl)
 	if err != nil {
-		return fmt.Errorf("Error reading CA certificate: %v", err)
+		return err
+	}
+
+	fmt.Printf("Connecting to MQTT broker at %s\n", config.BrokerUrl)
+	fmt.Printf("Using Client ID: %s\n", config.ClientId)
+
+	if config.PersistentSession && config.ClientId == "" {
+		return fmt.Errorf("A stable MQTT client ID is required for persistent sessions")
 	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
+	var tlsConfig *tls.Config
+	if secure {
+		tlsConfig, err = buildTLSConfig(config)
+		if err != nil {
+			return err
+		}
+	}
 
-	clientCert, err := tls.LoadX509KeyPair(config.ClientCrtFilePath, config.ClientKeyFilePath)
+	if config.Username != "" {
+		fmt.Printf("Using MQTT username: %s\n", config.Username)
+	}
+
+	switch config.ProtocolVersion {
+	case 0, 3, 4:
+		worker.transport = newMQTTV3Transport(worker, tlsConfig)
+	case 5:
+		fmt.Println("Using MQTT 5")
+		err = worker.SubscribeExclusive(worker.ResponseTopic(), 1, worker.handleResponse)
+		if err != nil {
+			return err
+		}
+		worker.transport = newMQTTV5Transport(worker, tlsConfig)
+	default:
+		return fmt.Errorf("Unsupported MQTT protocol version: %d", config.ProtocolVersion)
+	}
+
+	if err := worker.transport.Connect(); err != nil {
+		if hint := tlsVerificationHint(err); hint != "" {
+			return fmt.Errorf("Error verifying MQTT broker certificate: %w (%s)", err, hint)
+		}
+		return fmt.Errorf("Error connecting to MQTT broker: %v", err)
+	}
+
+	return nil
+}
+
+// Stop disconnects from the broker and waits for the queued messages to be
+// processed.
+func (worker *MQTTClient) Stop() {
+	if worker.transport != nil {
+		// A clean disconnect does not trigger the Last Will, so announce it here
+		err := worker.Publish(context.Background(), worker.StatusTopic(), []byte(StatusOffline), 1, true)
+		if err != nil {
+			fmt.Printf("Failed to publish offline status: %v\n", err)
+		}
+		worker.transport.Disconnect()
+	}
+	worker.pipeline.Stop()
+	fmt.Println("MQTT client stopped")
+}
+
+func isSecureBrokerUrl(brokerUrl string) (bool, error) {
+	uri, err := url.Parse(brokerUrl)
 	if err != nil {
-		return fmt.Errorf("Error reading client certificate: %v", err)
+		return false, fmt.Errorf("Invalid MQTT broker URL %s: %v", brokerUrl, err)
+	}
+
+	switch uri.Scheme {
+	case "tcp", "mqtt", "ws":
+		return false, nil
+	case "ssl", "tls", "mqtts", "tcps", "wss":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Unsupported MQTT broker URL scheme: %s", uri.Scheme)
+	}
+}
+
+// buildTLSConfig verifies the broker against the configured CA, or the system
+// roots when no CA file is set. The client certificate is only presented when
+// both certificate and key files are configured (mutual TLS).
+func buildTLSConfig(config MQTTConfig) (*tls.Config, error) {
+	minVersion := config.TLSMinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
 	}
 
 	tlsConfig := &tls.Config{
-		RootCAs:            caCertPool,
-		Certificates:       []tls.Certificate{clientCert},
-		InsecureSkipVerify: true, // TODO: Need to fix SAN field on certificate to make this production ready
+		ServerName:         config.TLSServerName,
+		MinVersion:         minVersion,
+		InsecureSkipVerify: config.TLSInsecureSkipVerify,
 	}
 
-	opts := mqtt.NewClientOptions()
-	fmt.Printf("Connecting to MQTT broker at %s\n", config.BrokerUrl)
-	fmt.Printf("Using Client ID: %s\n", config.ClientId)
+	if config.CaFilePath != "" {
+		caCert, err := os.ReadFile(config.CaFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA certificate: %v", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("Error reading CA certificate: no PEM certificates found in %s", config.CaFilePath)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
 
-	opts.AddBroker(config.BrokerUrl)
-	opts.SetTLSConfig(tlsConfig)
-	opts.SetClientID(config.ClientId)
-	opts.SetKeepAlive(2 * time.Second)
-	opts.SetPingTimeout(1 * time.Second)
-	opts.SetAutoReconnect(true)
+	if config.ClientCrtFilePath != "" && config.ClientKeyFilePath != "" {
+		clientCert, err := tls.LoadX509KeyPair(config.ClientCrtFilePath, config.ClientKeyFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
 
-	var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-		fmt.Printf("MQTT connection lost: %v\n", err)
+	if config.TLSInsecureSkipVerify {
+		fmt.Println("Warning: MQTT broker certificate verification is disabled")
 	}
 
-	opts.SetConnectionLostHandler(connectLostHandler)
+	return tlsConfig, nil
+}
 
-	worker.client = mqtt.NewClient(opts)
-	if token := worker.client.Connect(); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("Error connecting to MQTT broker: %v", token.Error())
+// ParseTLSVersion converts a version string such as "1.2" or "1.3" into the
+// crypto/tls constant. An empty string selects the default (TLS 1.2).
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version: %s", version)
 	}
+}
 
-	return nil
+func tlsVerificationHint(err error) string {
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return "the certificate SAN does not match the broker host, set MQTT_TLS_SERVER_NAME to the name on the certificate"
+	}
+
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityErr) {
+		return "the broker certificate is not signed by the configured CA"
+	}
+
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		return "the broker certificate is invalid or expired"
+	}
+
+	var verificationErr *tls.CertificateVerificationError
+	if errors.As(err, &verificationErr) {
+		return "the broker certificate could not be verified"
+	}
+
+	return ""
 }
 
+// onConnect runs on the initial connection and after every automatic
+// reconnect. With a clean session the broker forgot our subscriptions, and a
+// persistent one may have expired, so every registered handler is subscribed
+// again.
+func (worker *MQTTClient) onConnect() {
+	connects := worker.connects.Add(1)
+	if connects > 1 {
+		fmt.Printf("Reconnected to MQTT broker (reconnect #%d), restoring subscriptions\n", connects-1)
+	} else {
+		fmt.Println("Connected to MQTT broker")
+	}
+
+	err := worker.Publish(context.Background(), worker.StatusTopic(), []byte(StatusOnline), 1, true)
+	if err != nil {
+		fmt.Printf("Failed to publish birth message: %v\n", err)
+	}
+
+	worker.handlersMu.RLock()
+	subscriptions := make(map[string]subscription, len(worker.handlers))
+	for topic, sub := range worker.handlers {
+		subscriptions[topic] = sub
+	}
+	worker.handlersMu.RUnlock()
+
+	for topic, sub := range subscriptions {
+		if err := worker.subscribe(topic, sub); err != nil {
+			fmt.Printf("Failed to restore subscription: %v\n", err)
+		}
+	}
+
+	worker.connectListenersMu.Lock()
+	listeners := append([]func(){}, worker.connectListeners...)
+	worker.connectListenersMu.Unlock()
+
+	for _, listener := range listeners {
+		listener()
+	}
+}
+
+func (worker *MQTTClient) onConnectionLost(err error) {
+	losses := worker.connectionLosses.Add(1)
+	fmt.Printf("MQTT connection lost (%d so far): %v\n", losses, err)
+}
+
+// Subscribe registers a handler for a topic filter using the configured default
+// QoS. Handlers registered before Start are subscribed once the connection is
+// established.
 func (worker *MQTTClient) Subscribe(topic string, handler MqttMessageHandler) error {
-	if worker.client == nil {
-		return fmt.Errorf("MQTT client is not connected, please start the worker first")
+	return worker.SubscribeWithQoS(topic, worker.config.DefaultQoS, handler)
+}
+
+func (worker *MQTTClient) SubscribeWithQoS(topic string, qos byte, handler MqttMessageHandler) error {
+	group, filter := SplitSharedSubscription(topic)
+	if group == "" {
+		group = worker.config.SharedSubscriptionGroup
+	}
+	return worker.addSubscription(filter, subscription{qos: qos, group: group, handler: handler})
+}
+
+// SubscribeExclusive never uses the shared subscription group, for topics that
+// must reach every worker replica (e.g. replies addressed to this client).
+func (worker *MQTTClient) SubscribeExclusive(topic string, qos byte, handler MqttMessageHandler) error {
+	return worker.addSubscription(topic, subscription{qos: qos, handler: handler})
+}
+
+func (worker *MQTTClient) addSubscription(topic string, sub subscription) error {
+	if err := ValidateTopicFilter(topic); err != nil {
+		return err
+	}
+
+	if sub.qos > 2 {
+		return fmt.Errorf("Invalid QoS %d for topic %s", sub.qos, topic)
 	}
 
-	if _, exists := worker.handlers[topic]; exists {
+	worker.handlersMu.Lock()
+	previous, exists := worker.handlers[topic]
+	worker.handlers[topic] = sub
+	worker.handlersMu.Unlock()
+
+	if exists {
 		fmt.Printf("Handler for topic %s already exists and will be ovewritten\n", topic)
-		worker.client.Unsubscribe(topic)
+		if previous.qos == sub.qos && previous.group == sub.group {
+			return nil
+		}
+		if previous.group != sub.group && worker.isConnectionOpen() {
+			worker.transport.Unsubscribe(SharedSubscriptionFilter(previous.group, topic))
+		}
+	}
+
+	fmt.Printf("Adding handler for topic %s (qos=%d)\n", topic, sub.qos)
+
+	if !worker.isConnectionOpen() {
+		return nil
 	}
 
-	worker.handlers[topic] = handler
+	return worker.subscribe(topic, sub)
+}
+
+func (worker *MQTTClient) subscribe(topic string, sub subscription) error {
+	brokerFilter := SharedSubscriptionFilter(sub.group, topic)
+	if err := worker.transport.Subscribe(brokerFilter, sub.qos); err != nil {
+		return fmt.Errorf("Error subscribing to topic %s: %v", brokerFilter, err)
+	}
 
-	fmt.Printf("Adding handler for topic %s\n", topic)
+	return nil
+}
+
+func (worker *MQTTClient) isConnectionOpen() bool {
+	return worker.transport != nil && worker.transport.IsConnectionOpen()
+}
 
-	worker.client.Subscribe(topic, 0, func(client mqtt.Client, msg mqtt.Message) {
-		handler := worker.handlers[msg.Topic()]
-		if handler == nil {
-			fmt.Printf("Subscription with no handler registered for topic %s\n", msg.Topic())
-			return
+// route queues a message for every handler whose subscription filter matches
+// the message topic, so wildcard subscriptions receive per-device topics.
+func (worker *MQTTClient) route(msg mqtt.Message) {
+	worker.handlersMu.RLock()
+	var matched []MqttMessageHandler
+	for filter, sub := range worker.handlers {
+		if TopicMatchesFilter(filter, msg.Topic()) {
+			matched = append(matched, sub.handler)
 		}
-		handler(msg)
+	}
+	worker.handlersMu.RUnlock()
+
+	if len(matched) == 0 {
+		fmt.Printf("Subscription with no handler registered for topic %s\n", msg.Topic())
+		msg.Ack()
+		return
+	}
+
+	worker.pipeline.Enqueue(msg, matched)
+}
+
+func (worker *MQTTClient) Publish(ctx context.Context, topic string, payload []byte, qos byte, retained bool) error {
+	return worker.PublishMessage(ctx, OutgoingMessage{
+		Topic:    topic,
+		Payload:  payload,
+		QoS:      qos,
+		Retained: retained,
 	})
+}
+
+func (worker *MQTTClient) PublishMessage(ctx context.Context, msg OutgoingMessage) error {
+	if !worker.isConnectionOpen() {
+		return fmt.Errorf("MQTT client is not connected, cannot publish to topic %s", msg.Topic)
+	}
+
+	if msg.QoS > 2 {
+		return fmt.Errorf("Invalid QoS %d for topic %s", msg.QoS, msg.Topic)
+	}
+
+	if msg.hasProperties() && !worker.IsV5() {
+		return fmt.Errorf("Publish properties on topic %s require MQTT 5", msg.Topic)
+	}
+
+	timeout := worker.config.PublishTimeout
+	if timeout <= 0 {
+		timeout = DefaultPublishTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := worker.transport.Publish(ctx, msg); err != nil {
+		return fmt.Errorf("Error publishing to topic %s: %w", msg.Topic, err)
+	}
 
+	fmt.Printf("Published message to topic %s (qos=%d, retained=%t)\n", msg.Topic, msg.QoS, msg.Retained)
 	return nil
 }
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttV3Transport speaks MQTT 3.1/3.1.1 through paho.mqtt.golang.
+type mqttV3Transport struct {
+	worker    *MQTTClient
+	tlsConfig *tls.Config
+	client    mqtt.Client
+}
+
+func newMQTTV3Transport(worker *MQTTClient, tlsConfig *tls.Config) *mqttV3Transport {
+	return &mqttV3Transport{
+		worker:    worker,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (t *mqttV3Transport) Connect() error {
+	config := t.worker.config
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(config.BrokerUrl)
+	opts.SetClientID(config.ClientId)
+
+	if config.ProtocolVersion != 0 {
+		opts.SetProtocolVersion(config.ProtocolVersion)
+	}
+
+	if config.PersistentSession && config.StoreDirectory != "" {
+		fmt.Printf("Using MQTT in-flight store at %s\n", config.StoreDirectory)
+		opts.SetStore(mqtt.NewFileStore(config.StoreDirectory))
+	}
+
+	// Messages are acknowledged once the pipeline has processed them, so a
+	// crash before the database insert leads to a redelivery instead of a loss
+	opts.SetCleanSession(!config.PersistentSession)
+	opts.SetAutoAckDisabled(true)
+	// Queued session messages can arrive before the subscriptions are restored
+	opts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
+		t.worker.route(msg)
+	})
+
+	if t.tlsConfig != nil {
+		opts.SetTLSConfig(t.tlsConfig)
+	}
+
+	if config.Username != "" {
+		opts.SetUsername(config.Username)
+		opts.SetPassword(config.Password)
+	}
+
+	opts.SetWill(t.worker.StatusTopic(), StatusOffline, 1, true)
+	opts.SetKeepAlive(2 * time.Second)
+	opts.SetPingTimeout(1 * time.Second)
+	opts.SetAutoReconnect(true)
+
+	var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
+		t.worker.onConnectionLost(err)
+	}
+
+	var reconnectingHandler mqtt.ReconnectHandler = func(client mqtt.Client, opts *mqtt.ClientOptions) {
+		fmt.Printf("Reconnecting to MQTT broker at %s\n", config.BrokerUrl)
+	}
+
+	var onConnectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
+		t.worker.onConnect()
+	}
+
+	opts.SetConnectionLostHandler(connectLostHandler)
+	opts.SetReconnectingHandler(reconnectingHandler)
+	opts.SetOnConnectHandler(onConnectHandler)
+
+	t.client = mqtt.NewClient(opts)
+	if token := t.client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+func (t *mqttV3Transport) IsConnected() bool {
+	return t.client != nil && t.client.IsConnected()
+}
+
+func (t *mqttV3Transport) IsConnectionOpen() bool {
+	return t.client != nil && t.client.IsConnectionOpen()
+}
+
+func (t *mqttV3Transport) Subscribe(filter string, qos byte) error {
+	token := t.client.Subscribe(filter, qos, func(client mqtt.Client, msg mqtt.Message) {
+		t.worker.route(msg)
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+func (t *mqttV3Transport) Unsubscribe(filter string) error {
+	token := t.client.Unsubscribe(filter)
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+func (t *mqttV3Transport) Publish(ctx context.Context, msg OutgoingMessage) error {
+	token := t.client.Publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload)
+
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *mqttV3Transport) Disconnect() {
+	t.client.Disconnect(250)
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.golang/paho/session/state"
+	"github.com/eclipse/paho.golang/paho/store/file"
+)
+
+const (
+	// DefaultSessionExpiry keeps a persistent MQTT 5 session (and the messages
+	// queued in it) for a day after the worker disconnects.
+	DefaultSessionExpiry = 24 * time.Hour
+	v5ConnectTimeout     = 10 * time.Second
+	v5KeepAliveSeconds   = 2
+)
+
+// mqttV5Transport speaks MQTT 5 through the paho.golang autopaho connection
+// manager, which handles reconnects on its own.
+type mqttV5Transport struct {
+	worker    *MQTTClient
+	tlsConfig *tls.Config
+
+	manager   *autopaho.ConnectionManager
+	cancel    context.CancelFunc
+	connected atomic.Bool
+
+	lastErrMu sync.Mutex
+	lastErr   error
+}
+
+func newMQTTV5Transport(worker *MQTTClient, tlsConfig *tls.Config) *mqttV5Transport {
+	return &mqttV5Transport{
+		worker:    worker,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (t *mqttV5Transport) Connect() error {
+	config := t.worker.config
+
+	brokerUrl, err := url.Parse(config.BrokerUrl)
+	if err != nil {
+		return err
+	}
+
+	session := state.NewInMemory()
+	if config.PersistentSession && config.StoreDirectory != "" {
+		session, err = newFileSession(config.StoreDirectory)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Using MQTT in-flight store at %s\n", config.StoreDirectory)
+	}
+
+	var sessionExpiry uint32
+	if config.PersistentSession {
+		sessionExpiry = uint32(DefaultSessionExpiry.Seconds())
+	}
+
+	clientConfig := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{brokerUrl},
+		TlsCfg:                        t.tlsConfig,
+		KeepAlive:                     v5KeepAliveSeconds,
+		CleanStartOnInitialConnection: !config.PersistentSession,
+		SessionExpiryInterval:         sessionExpiry,
+		ConnectRetryDelay:             5 * time.Second,
+		ConnectTimeout:                v5ConnectTimeout,
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+			t.connected.Store(true)
+			// Subscribing blocks, and autopaho callbacks must not
+			go t.worker.onConnect()
+		},
+		OnConnectionDown: func() bool {
+			t.connected.Store(false)
+			t.worker.onConnectionLost(fmt.Errorf("connection with the MQTT server is down"))
+			return true
+		},
+		OnConnectError: func(err error) {
+			t.lastErrMu.Lock()
+			t.lastErr = err
+			t.lastErrMu.Unlock()
+			fmt.Printf("MQTT connection attempt failed: %v\n", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: config.ClientId,
+			Session:  session,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(received paho.PublishReceived) (bool, error) {
+					t.worker.route(newV5Message(received.Client, received.Packet))
+					return true, nil
+				},
+			},
+			// Messages are acknowledged once the pipeline has processed them
+			EnableManualAcknowledgment: true,
+		},
+	}
+
+	if config.Username != "" {
+		clientConfig.SetUsernamePassword(config.Username, []byte(config.Password))
+	}
+	clientConfig.SetWillMessage(t.worker.StatusTopic(), []byte(StatusOffline), 1, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+
+	t.manager, err = autopaho.NewConnection(ctx, clientConfig)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	connectCtx, connectCancel := context.WithTimeout(ctx, v5ConnectTimeout)
+	defer connectCancel()
+
+	if err := t.manager.AwaitConnection(connectCtx); err != nil {
+		cancel()
+		t.lastErrMu.Lock()
+		defer t.lastErrMu.Unlock()
+		if t.lastErr != nil {
+			return t.lastErr
+		}
+		return err
+	}
+
+	return nil
+}
+
+func newFileSession(directory string) (*state.State, error) {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create MQTT store directory: %w", err)
+	}
+
+	clientStore, err := file.New(directory, "client", ".pkt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MQTT client store: %w", err)
+	}
+
+	serverStore, err := file.New(directory, "server", ".pkt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MQTT server store: %w", err)
+	}
+
+	return state.New(clientStore, serverStore), nil
+}
+
+// IsConnected stays true while autopaho is reconnecting, matching the
+// behaviour of the MQTT 3.1.1 client with auto reconnect enabled.
+func (t *mqttV5Transport) IsConnected() bool {
+	if t.manager == nil {
+		return false
+	}
+
+	select {
+	case <-t.manager.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+func (t *mqttV5Transport) IsConnectionOpen() bool {
+	return t.connected.Load()
+}
+
+func (t *mqttV5Transport) Subscribe(filter string, qos byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5ConnectTimeout)
+	defer cancel()
+
+	_, err := t.manager.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: filter, QoS: qos}},
+	})
+	return err
+}
+
+func (t *mqttV5Transport) Unsubscribe(filter string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5ConnectTimeout)
+	defer cancel()
+
+	_, err := t.manager.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{filter}})
+	return err
+}
+
+func (t *mqttV5Transport) Publish(ctx context.Context, msg OutgoingMessage) error {
+	publish := &paho.Publish{
+		Topic:   msg.Topic,
+		QoS:     msg.QoS,
+		Retain:  msg.Retained,
+		Payload: msg.Payload,
+	}
+
+	if msg.hasProperties() {
+		publish.Properties = &paho.PublishProperties{
+			ResponseTopic:   msg.ResponseTopic,
+			CorrelationData: msg.CorrelationData,
+		}
+		for key, value := range msg.UserProperties {
+			publish.Properties.User.Add(key, value)
+		}
+	}
+
+	_, err := t.manager.Publish(ctx, publish)
+	return err
+}
+
+func (t *mqttV5Transport) Disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	if err := t.manager.Disconnect(ctx); err != nil {
+		fmt.Printf("Error disconnecting from MQTT broker: %v\n", err)
+	}
+	t.cancel()
+}
+
+// v5Message adapts a paho.golang publish to the mqtt.Message interface used by
+// the handlers, and exposes the MQTT 5 properties through MessageProperties.
+type v5Message struct {
+	client  *paho.Client
+	packet  *paho.Publish
+	ackOnce sync.Once
+}
+
+func newV5Message(client *paho.Client, packet *paho.Publish) *v5Message {
+	return &v5Message{client: client, packet: packet}
+}
+
+func (m *v5Message) Duplicate() bool   { return m.packet.Duplicate() }
+func (m *v5Message) Qos() byte         { return m.packet.QoS }
+func (m *v5Message) Retained() bool    { return m.packet.Retain }
+func (m *v5Message) Topic() string     { return m.packet.Topic }
+func (m *v5Message) MessageID() uint16 { return m.packet.PacketID }
+func (m *v5Message) Payload() []byte   { return m.packet.Payload }
+
+func (m *v5Message) Ack() {
+	m.ackOnce.Do(func() {
+		if err := m.client.Ack(m.packet); err != nil {
+			fmt.Printf("Failed to acknowledge message on topic %s: %v\n", m.packet.Topic, err)
+		}
+	})
+}
+
+func (m *v5Message) ResponseTopic() string {
+	if m.packet.Properties == nil {
+		return ""
+	}
+	return m.packet.Properties.ResponseTopic
+}
+
+func (m *v5Message) CorrelationData() []byte {
+	if m.packet.Properties == nil {
+		return nil
+	}
+	return m.packet.Properties.CorrelationData
+}
+
+func (m *v5Message) UserProperties() map[string]string {
+	properties := make(map[string]string)
+	if m.packet.Properties == nil {
+		return properties
+	}
+	for _, property := range m.packet.Properties.User {
+		properties[property.Key] = property.Value
+	}
+	return properties
+}
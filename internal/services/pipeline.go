@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, up to BlockTimeout, before
+	// dropping the message.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the message as soon as the queue is full.
+	OverflowDrop
+)
+
+const (
+	DefaultPipelineWorkers      = 4
+	DefaultPipelineQueueSize    = 256
+	DefaultPipelineBlockTimeout = 1 * time.Second
+)
+
+type PipelineConfig struct {
+	Workers        int
+	QueueSize      int
+	OverflowPolicy OverflowPolicy
+	// BlockTimeout bounds how long the MQTT callback may be held by the block
+	// policy, so a slow database cannot starve the client keepalive.
+	BlockTimeout time.Duration
+}
+
+type PipelineStats struct {
+	Processed  int64 `json:"processed"`
+	Dropped    int64 `json:"dropped"`
+	QueueDepth int   `json:"queue_depth"`
+}
+
+type pipelineJob struct {
+	msg      mqtt.Message
+	handlers []MqttMessageHandler
+}
+
+// MessagePipeline decouples the paho callback from the device handlers. Every
+// topic is pinned to a single worker so messages of the same topic are
+// processed in the order they were received.
+type MessagePipeline struct {
+	config PipelineConfig
+	queues []chan pipelineJob
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex
+	stopped bool
+
+	processed atomic.Int64
+	dropped   atomic.Int64
+}
+
+func ParseOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "block":
+		return OverflowBlock, nil
+	case "drop":
+		return OverflowDrop, nil
+	default:
+		return OverflowBlock, fmt.Errorf("unsupported overflow policy: %s", policy)
+	}
+}
+
+func NewMessagePipeline(config PipelineConfig) *MessagePipeline {
+	if config.Workers <= 0 {
+		config.Workers = DefaultPipelineWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultPipelineQueueSize
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = DefaultPipelineBlockTimeout
+	}
+
+	pipeline := &MessagePipeline{
+		config: config,
+		queues: make([]chan pipelineJob, config.Workers),
+	}
+
+	for i := range pipeline.queues {
+		pipeline.queues[i] = make(chan pipelineJob, config.QueueSize)
+		pipeline.wg.Add(1)
+		go pipeline.run(pipeline.queues[i])
+	}
+
+	return pipeline
+}
+
+// Enqueue hands a message to the worker owning its topic. It returns false if
+// the message was dropped because the queue is full or the pipeline stopped.
+func (p *MessagePipeline) Enqueue(msg mqtt.Message, handlers []MqttMessageHandler) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		// Not acknowledged: a persistent session gets it redelivered on restart
+		p.dropped.Add(1)
+		return false
+	}
+
+	queue := p.queues[p.queueIndex(msg.Topic())]
+	job := pipelineJob{msg: msg, handlers: handlers}
+
+	select {
+	case queue <- job:
+		return true
+	default:
+	}
+
+	if p.config.OverflowPolicy == OverflowBlock {
+		timer := time.NewTimer(p.config.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case queue <- job:
+			return true
+		case <-timer.C:
+		}
+	}
+
+	// Dropped messages are acknowledged anyway, otherwise they would hold the
+	// broker in-flight window until the next reconnect
+	msg.Ack()
+	dropped := p.dropped.Add(1)
+	fmt.Printf("Message pipeline queue full, dropping message on topic %s (%d dropped so far)\n", msg.Topic(), dropped)
+	return false
+}
+
+// Stop stops accepting messages and waits for the queued ones to be processed.
+func (p *MessagePipeline) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *MessagePipeline) Stats() PipelineStats {
+	depth := 0
+	for _, queue := range p.queues {
+		depth += len(queue)
+	}
+
+	return PipelineStats{
+		Processed:  p.processed.Load(),
+		Dropped:    p.dropped.Load(),
+		QueueDepth: depth,
+	}
+}
+
+func (p *MessagePipeline) queueIndex(topic string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(topic))
+	return int(hash.Sum32() % uint32(len(p.queues)))
+}
+
+func (p *MessagePipeline) run(queue chan pipelineJob) {
+	defer p.wg.Done()
+
+	for job := range queue {
+		for _, handler := range job.handlers {
+			p.handle(handler, job.msg)
+		}
+		job.msg.Ack()
+		p.processed.Add(1)
+	}
+}
+
+func (p *MessagePipeline) handle(handler MqttMessageHandler, msg mqtt.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Recovered from panic while handling message on topic %s: %v\n", msg.Topic(), r)
+		}
+	}()
+
+	handler(msg)
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const DefaultRequestTimeout = 10 * time.Second
+
+var ErrRequestRequiresV5 = errors.New("MQTT request/response requires MQTT 5")
+
+// ResponseTopic is where devices publish replies to requests sent by this
+// client. It is subscribed exclusively, so replies always reach the replica
+// that sent the request even with shared subscriptions enabled.
+func (worker *MQTTClient) ResponseTopic() string {
+	return fmt.Sprintf("mqtt-home-server-worker/%s/responses", worker.config.ClientId)
+}
+
+// PendingRequest is a published request waiting for its reply.
+type PendingRequest struct {
+	worker        *MQTTClient
+	correlationId string
+	topic         string
+	reply         chan mqtt.Message
+}
+
+// Request publishes a message carrying a response topic and a random
+// correlation ID, then waits for the reply echoing that correlation ID. Without
+// a deadline on ctx, DefaultRequestTimeout applies.
+func (worker *MQTTClient) Request(ctx context.Context, topic string, payload []byte, qos byte) (mqtt.Message, error) {
+	request, err := worker.StartRequest(ctx, topic, payload, qos)
+	if err != nil {
+		return nil, err
+	}
+	return request.Wait(ctx)
+}
+
+// StartRequest publishes a request without waiting for the reply, which is
+// then received with Wait.
+func (worker *MQTTClient) StartRequest(ctx context.Context, topic string, payload []byte, qos byte) (*PendingRequest, error) {
+	if !worker.IsV5() {
+		return nil, ErrRequestRequiresV5
+	}
+
+	correlationId, err := newCorrelationId()
+	if err != nil {
+		return nil, err
+	}
+
+	request := &PendingRequest{
+		worker:        worker,
+		correlationId: correlationId,
+		topic:         topic,
+		reply:         make(chan mqtt.Message, 1),
+	}
+
+	// Registered before publishing so a fast reply is not missed
+	worker.requestsMu.Lock()
+	worker.requests[correlationId] = request.reply
+	worker.requestsMu.Unlock()
+
+	err = worker.PublishMessage(ctx, OutgoingMessage{
+		Topic:           topic,
+		Payload:         payload,
+		QoS:             qos,
+		ResponseTopic:   worker.ResponseTopic(),
+		CorrelationData: []byte(correlationId),
+	})
+	if err != nil {
+		request.close()
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Wait returns the reply to the request. Without a deadline on ctx,
+// DefaultRequestTimeout applies.
+func (request *PendingRequest) Wait(ctx context.Context) (mqtt.Message, error) {
+	defer request.close()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+
+	select {
+	case msg := <-request.reply:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Error waiting for response to request %s on topic %s: %w", request.correlationId, request.topic, ctx.Err())
+	}
+}
+
+func (request *PendingRequest) close() {
+	request.worker.requestsMu.Lock()
+	delete(request.worker.requests, request.correlationId)
+	request.worker.requestsMu.Unlock()
+}
+
+func (worker *MQTTClient) handleResponse(msg mqtt.Message) {
+	properties, ok := msg.(MessageProperties)
+	if !ok || len(properties.CorrelationData()) == 0 {
+		fmt.Printf("Ignoring response without correlation data on topic %s\n", msg.Topic())
+		return
+	}
+
+	correlationId := string(properties.CorrelationData())
+
+	worker.requestsMu.Lock()
+	reply, exists := worker.requests[correlationId]
+	worker.requestsMu.Unlock()
+
+	if !exists {
+		fmt.Printf("Ignoring response for unknown or expired request %s\n", correlationId)
+		return
+	}
+
+	select {
+	case reply <- msg:
+	default:
+		fmt.Printf("Ignoring duplicated response for request %s\n", correlationId)
+	}
+}
+
+func newCorrelationId() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate correlation ID: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
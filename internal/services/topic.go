@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateTopicFilter checks that the MQTT wildcards "+" and "#" are only used
+// as whole topic levels and that "#" is always the last level.
+func ValidateTopicFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("topic filter cannot be empty")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("invalid topic filter %s: '#' must be the last level", filter)
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("invalid topic filter %s: '+' must occupy a whole level", filter)
+		}
+	}
+
+	return nil
+}
+
+// TopicMatchesFilter reports whether a concrete topic name matches a
+// subscription filter using the MQTT "+" (single level) and "#" (multi level)
+// wildcards. Topics starting with "$" are not matched by a leading wildcard.
+func TopicMatchesFilter(filter string, topic string) bool {
+	if filter == topic {
+		return true
+	}
+
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+const sharedSubscriptionPrefix = "$share/"
+
+// SharedSubscriptionFilter prefixes a topic filter with $share/<group>/ when a
+// group is set. The broker delivers each message to a single group member.
+func SharedSubscriptionFilter(group string, filter string) string {
+	if group == "" {
+		return filter
+	}
+	return sharedSubscriptionPrefix + group + "/" + filter
+}
+
+// SplitSharedSubscription returns the group and the topic filter of a
+// $share/<group>/<filter> subscription. Messages are published on the plain
+// topic, so only the filter is used for routing.
+func SplitSharedSubscription(filter string) (string, string) {
+	if !strings.HasPrefix(filter, sharedSubscriptionPrefix) {
+		return "", filter
+	}
+
+	group, topicFilter, found := strings.Cut(strings.TrimPrefix(filter, sharedSubscriptionPrefix), "/")
+	if !found {
+		return "", filter
+	}
+	return group, topicFilter
+}
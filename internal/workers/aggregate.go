@@ -0,0 +1,44 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxAggregateBuckets caps the intervals a single aggregation may return.
+const MaxAggregateBuckets = 10000
+
+// ErrInvalidInterval is wrapped by ValidateAggregateInterval.
+var ErrInvalidInterval = errors.New("invalid aggregation interval")
+
+// ValidateAggregateInterval accepts 0, which returns the raw readings, and
+// positive intervals splitting the range in at most MaxAggregateBuckets.
+func ValidateAggregateInterval(intervalMs int, startTime, endTime time.Time) error {
+	if intervalMs == 0 {
+		return nil
+	}
+	_, _, err := AggregateBuckets(intervalMs, startTime, endTime)
+	return err
+}
+
+// AggregateBuckets returns how many intervals of intervalMs cover the range
+// from startTime to endTime, the last one possibly partial.
+func AggregateBuckets(intervalMs int, startTime, endTime time.Time) (int, time.Duration, error) {
+	if intervalMs <= 0 {
+		return 0, 0, fmt.Errorf("%w: interval_ms must be positive", ErrInvalidInterval)
+	}
+
+	interval := time.Duration(intervalMs) * time.Millisecond
+	span := endTime.Sub(startTime)
+	if span <= 0 {
+		return 0, interval, nil
+	}
+
+	count := (span + interval - 1) / interval
+	if count > MaxAggregateBuckets {
+		return 0, 0, fmt.Errorf("%w: the range holds %d intervals, the maximum is %d", ErrInvalidInterval, count, MaxAggregateBuckets)
+	}
+
+	return int(count), interval, nil
+}
@@ -0,0 +1,107 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+const (
+	DefaultCommandAckTimeout    = 30 * time.Second
+	DefaultCommandCheckInterval = 5 * time.Second
+)
+
+// CommandRetryPolicy controls how long a sent command waits for its ack and
+// how many times it is republished before being marked as timed out.
+type CommandRetryPolicy struct {
+	AckTimeout    time.Duration
+	MaxRetries    int
+	CheckInterval time.Duration
+}
+
+// CommandTracker periodically retries and expires the commands still waiting
+// for an ack. The state lives in device_commands, so acks received by any
+// replica are taken into account.
+type CommandTracker struct {
+	db       *database.Database
+	registry *Registry
+	policy   CommandRetryPolicy
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewCommandTracker(db *database.Database, registry *Registry, policy CommandRetryPolicy) *CommandTracker {
+	if policy.AckTimeout <= 0 {
+		policy.AckTimeout = DefaultCommandAckTimeout
+	}
+	if policy.CheckInterval <= 0 {
+		policy.CheckInterval = DefaultCommandCheckInterval
+	}
+	if policy.MaxRetries < 0 {
+		policy.MaxRetries = 0
+	}
+
+	return &CommandTracker{
+		db:       db,
+		registry: registry,
+		policy:   policy,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (t *CommandTracker) Start() {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		ticker := time.NewTicker(t.policy.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.check(context.Background())
+			}
+		}
+	}()
+}
+
+func (t *CommandTracker) Stop() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+func (t *CommandTracker) check(ctx context.Context) {
+	cr := t.db.CommandRepository()
+	maxAttempts := t.policy.MaxRetries + 1
+
+	expired, err := cr.ExpireCommands(ctx, t.policy.AckTimeout, maxAttempts)
+	if err != nil {
+		fmt.Printf("Failed to expire commands: %v\n", err)
+	}
+	for _, command := range expired {
+		fmt.Printf("Command %d (%s) timed out after %d attempts\n", command.ID, command.Command, command.Attempts)
+	}
+
+	retries, err := cr.ClaimCommandsForRetry(ctx, t.policy.AckTimeout, maxAttempts)
+	if err != nil {
+		fmt.Printf("Failed to claim commands for retry: %v\n", err)
+		return
+	}
+
+	for _, command := range retries {
+		fmt.Printf("Retrying command %d (%s), attempt %d of %d\n", command.ID, command.Command, command.Attempts, maxAttempts)
+
+		// A failed publish is retried on the next check while attempts remain
+		err := t.registry.RepublishCommand(ctx, &command)
+		if err != nil {
+			fmt.Printf("Failed to retry command %d: %v\n", command.ID, err)
+		}
+	}
+}
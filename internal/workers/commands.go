@@ -0,0 +1,174 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/services"
+)
+
+// ErrCommandsNotSupported is returned when the device type has no CommandWorker.
+var ErrCommandsNotSupported = errors.New("device type does not accept commands")
+
+// SendCommand validates and publishes a command to a device, recording it in
+// device_commands. The command ID is embedded in the payload as its sequence
+// so the device ack can be matched to it. Over MQTT 5 the command is sent as a
+// request and the reply matched by correlation ID acknowledges it, the ack
+// topic still works for devices that do not reply. A command that fails to
+// publish is stored as failed and returned together with the error.
+func (r *Registry) SendCommand(ctx context.Context, device *database.Device, command string, args json.RawMessage, sentBy string) (*database.DeviceCommand, error) {
+	worker, exists := r.ByDeviceType(device.Type)
+	if !exists {
+		return nil, fmt.Errorf("no device worker registered for type %s", device.Type)
+	}
+
+	commandWorker, ok := worker.(CommandWorker)
+	if !ok {
+		return nil, ErrCommandsNotSupported
+	}
+
+	// Validate before reserving an ID so rejected commands are not stored
+	if _, err := commandWorker.BuildCommand(device.FuseID, 0, command, args); err != nil {
+		return nil, err
+	}
+
+	cr := r.db.CommandRepository()
+	commandID, err := cr.NextCommandID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := commandWorker.BuildCommand(device.FuseID, commandID, command, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the published payload carries the secrets, the stored copy and the
+	// returned command are redacted
+	storedArgs, storedPayload, redacted := args, payload, false
+	if redactor, ok := worker.(CommandRedactor); ok {
+		storedArgs, storedPayload, redacted = redactor.RedactCommand(command, args, payload)
+	}
+
+	deviceCommand, err := cr.InsertCommand(ctx, commandID, device.ID, command, string(storedArgs), commandWorker.CommandTopic(), storedPayload, sentBy, redacted)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCommand.Status = database.CommandStatusSent
+	var request *services.PendingRequest
+	var publishErr error
+	if r.client.IsV5() {
+		request, publishErr = r.client.StartRequest(ctx, deviceCommand.Topic, []byte(payload), 1)
+	} else {
+		publishErr = r.client.Publish(ctx, deviceCommand.Topic, []byte(payload), 1, false)
+	}
+	if publishErr != nil {
+		deviceCommand.Status = database.CommandStatusFailed
+		deviceCommand.Error = publishErr.Error()
+	}
+
+	err = cr.UpdateCommandStatus(ctx, deviceCommand.ID, deviceCommand.Status, deviceCommand.Error)
+	if request != nil {
+		// Waited after the status update so a fast reply is not overwritten
+		go r.awaitReply(commandWorker, deviceCommand.ID, request)
+	}
+	if err != nil {
+		return deviceCommand, err
+	}
+
+	if publishErr != nil {
+		return deviceCommand, fmt.Errorf("failed to publish command %d: %w", deviceCommand.ID, publishErr)
+	}
+
+	return deviceCommand, nil
+}
+
+// RedactCommands replaces the secrets of stored commands of a device, also
+// covering the commands stored before they were redacted on insert.
+func (r *Registry) RedactCommands(device *database.Device, commands []database.DeviceCommand) {
+	worker, exists := r.ByDeviceType(device.Type)
+	redactor, ok := worker.(CommandRedactor)
+	if !exists || !ok {
+		return
+	}
+
+	for i := range commands {
+		args, payload, redacted := redactor.RedactCommand(commands[i].Command, json.RawMessage(commands[i].Args), commands[i].Payload)
+		if redacted {
+			commands[i].Args = string(args)
+			commands[i].Payload = payload
+			commands[i].Redacted = true
+		}
+	}
+}
+
+// RepublishCommand sends a stored command again. Over MQTT 5 it is sent as a
+// new request, so a device only replying to requests can ack the retry.
+func (r *Registry) RepublishCommand(ctx context.Context, command *database.DeviceCommand) error {
+	worker, exists := r.commandWorkerByTopic(command.Topic)
+	if !exists || !r.client.IsV5() {
+		return r.client.Publish(ctx, command.Topic, []byte(command.Payload), 1, false)
+	}
+
+	request, err := r.client.StartRequest(ctx, command.Topic, []byte(command.Payload), 1)
+	if err != nil {
+		return err
+	}
+	go r.awaitReply(worker, command.ID, request)
+
+	return nil
+}
+
+func (r *Registry) commandWorkerByTopic(topic string) (CommandWorker, bool) {
+	for _, worker := range r.workers {
+		if commandWorker, ok := worker.(CommandWorker); ok && commandWorker.CommandTopic() == topic {
+			return commandWorker, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) ackHandler(worker CommandWorker) services.MqttMessageHandler {
+	return func(msg mqtt.Message) {
+		fmt.Printf("Received command ack on topic %s: %s\n", msg.Topic(), string(msg.Payload()))
+		r.acknowledge(worker, msg)
+	}
+}
+
+// awaitReply acknowledges a command with the MQTT 5 reply to its request.
+func (r *Registry) awaitReply(worker CommandWorker, commandID int, request *services.PendingRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.commandAckTimeout)
+	defer cancel()
+
+	reply, err := request.Wait(ctx)
+	if err != nil {
+		fmt.Printf("No reply to command %d, waiting for its ack: %v\n", commandID, err)
+		return
+	}
+
+	fmt.Printf("Received reply to command %d: %s\n", commandID, string(reply.Payload()))
+	r.acknowledge(worker, reply)
+}
+
+func (r *Registry) acknowledge(worker CommandWorker, msg mqtt.Message) {
+	ack, err := worker.ParseAck(msg.Payload())
+	if err != nil {
+		fmt.Printf("Failed to parse command ack: %v\n", err)
+		return
+	}
+
+	matched, err := r.db.CommandRepository().AcknowledgeCommand(context.Background(), ack.Sequence, ack.FuseID, ack.Success, ack.Message)
+	if err != nil {
+		fmt.Printf("Failed to acknowledge command %d: %v\n", ack.Sequence, err)
+		return
+	}
+
+	if !matched {
+		fmt.Printf("Ignoring ack for unknown command %d from device %s\n", ack.Sequence, ack.FuseID)
+	}
+}
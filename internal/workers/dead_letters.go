@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+// deadLetter stores a message that failed to ingest so it can be reprocessed
+// after the parser or the database is fixed.
+func (r *Registry) deadLetter(topic string, worker DeviceWorker, payload []byte, ingestErr error) {
+	err := r.db.DeadLetterRepository().InsertDeadLetter(context.Background(), topic, worker.Info().Type, payload, ingestErr.Error())
+	if err != nil {
+		fmt.Printf("Failed to store dead letter for topic %s: %v\n", topic, err)
+	}
+}
+
+// ReprocessDeadLetter feeds a dead letter through its device worker again
+// and records the outcome.
+func (r *Registry) ReprocessDeadLetter(ctx context.Context, deadLetter *database.DeadLetter) error {
+	worker, exists := r.ByDeviceType(deadLetter.DeviceType)
+	if !exists {
+		worker, exists = r.ByTopic(deadLetter.Topic)
+	}
+	if !exists {
+		return fmt.Errorf("no device worker registered for dead letter %d on topic %s", deadLetter.ID, deadLetter.Topic)
+	}
+
+	_, ingestErr := r.IngestAt(ctx, worker, deadLetter.Payload, deadLetter.CreatedAt, false)
+
+	errorMessage := ""
+	if ingestErr != nil {
+		errorMessage = ingestErr.Error()
+	}
+
+	if err := r.db.DeadLetterRepository().RecordReprocessAttempt(ctx, deadLetter.ID, errorMessage); err != nil {
+		return err
+	}
+
+	return ingestErr
+}
@@ -0,0 +1,92 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+type DeviceInfo struct {
+	Type        string
+	Name        string
+	Description string
+	// TopicID is stored with every sensor_data row of this device type
+	TopicID int
+}
+
+// Reading is a device message decoded from its wire format.
+type Reading struct {
+	FuseID         string
+	ClientID       string
+	PayloadVersion int
+	Data           any
+	// Health is nil when the message carried no health section
+	Health *database.DeviceHealth
+	// DeviceTimestamp is when the device took the reading, nil when the
+	// payload does not carry it
+	DeviceTimestamp *time.Time
+}
+
+// DeviceWorker is implemented by every device type package. The registry
+// subscribes to its topics and stores the readings, while the HTTP layer uses
+// Decode and Aggregate to serve the stored data.
+type DeviceWorker interface {
+	Info() DeviceInfo
+	Topics() []string
+	// Parse decodes a raw MQTT payload, detecting the payload version
+	Parse(payload []byte) (*Reading, error)
+	// Encode compresses a reading for sensor_data, returning the storage
+	// version needed to decode it later
+	Encode(reading *Reading) (int, string, error)
+	// Decode converts a stored sensor_data payload into its API response
+	Decode(payloadVersion int, payload string) (any, error)
+	// Aggregate averages the stored rows into intervalMs buckets between start
+	// and end, or returns every row when intervalMs is 0
+	Aggregate(rows []database.SensorData, intervalMs int, start, end time.Time) (any, error)
+}
+
+// ErrInvalidCommand is wrapped by BuildCommand when the command is unknown or
+// its arguments fail validation.
+var ErrInvalidCommand = errors.New("invalid command")
+
+// CommandWorker is implemented by device workers whose devices accept commands.
+type CommandWorker interface {
+	CommandTopic() string
+	// AckTopics are the topics the devices acknowledge commands on
+	AckTopics() []string
+	// BuildCommand validates the JSON arguments and returns the wire payload
+	// carrying the sequence used to match the device acknowledgement
+	BuildCommand(fuseID string, sequence int, command string, args json.RawMessage) (string, error)
+	ParseAck(payload []byte) (*CommandAck, error)
+}
+
+// RedactedValue replaces the secrets of stored commands.
+const RedactedValue = "***"
+
+// CommandRedactor is implemented by command workers whose commands carry
+// secrets, such as wifi passwords, that must only reach the device.
+type CommandRedactor interface {
+	// RedactCommand returns the JSON arguments and wire payload with the
+	// secrets replaced by RedactedValue, and whether anything was replaced
+	RedactCommand(command string, args json.RawMessage, payload string) (json.RawMessage, string, bool)
+}
+
+// CommandAck is a device acknowledgement of the command with ID Sequence.
+type CommandAck struct {
+	FuseID   string
+	Sequence int
+	Success  bool
+	Message  string
+}
+
+// ThresholdWorker is implemented by command workers whose devices keep
+// thresholds that the server stores and pushes back after a reconnect.
+type ThresholdWorker interface {
+	CommandWorker
+	ThresholdMetrics() []string
+	// ThresholdCommand returns the command and JSON arguments applying the
+	// threshold of a metric
+	ThresholdCommand(metric string, min, max float64) (string, json.RawMessage, error)
+}
@@ -0,0 +1,48 @@
+package workers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+type EnergyPeriod string
+
+const (
+	EnergyPeriodDay   EnergyPeriod = "day"
+	EnergyPeriodMonth EnergyPeriod = "month"
+)
+
+func ParseEnergyPeriod(period string) (EnergyPeriod, error) {
+	switch EnergyPeriod(period) {
+	case EnergyPeriodDay, EnergyPeriodMonth:
+		return EnergyPeriod(period), nil
+	default:
+		return "", fmt.Errorf("unsupported energy period %q, use day or month", period)
+	}
+}
+
+// Start returns the beginning of the day or month containing t in location.
+func (p EnergyPeriod) Start(t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+	if p == EnergyPeriodMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, location)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+}
+
+// EnergyUsage is the energy consumed during the period beginning at Start.
+type EnergyUsage struct {
+	Start     time.Time `json:"start"`
+	EnergyKWh float64   `json:"energy_kwh"`
+	Readings  int       `json:"readings"`
+}
+
+// EnergyWorker is implemented by device workers reporting a cumulative energy
+// counter.
+type EnergyWorker interface {
+	// EnergyUsage sums the counter increases of the rows per period. The first
+	// row is only used as the starting counter value.
+	EnergyUsage(rows []database.SensorData, period EnergyPeriod, location *time.Location) ([]EnergyUsage, error)
+}
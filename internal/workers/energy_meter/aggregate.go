@@ -0,0 +1,129 @@
+package energy_meter_worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+// Aggregate averages power, voltage and current per interval over the readings
+// that reported them, keeping the last energy counter and relay state.
+// Intervals without readings are empty.
+func (em *EnergyMeterWorker) Aggregate(sensorDataCompressed []database.SensorData, interval_ms int, startTime, endTime time.Time) (any, error) {
+	sensorData := make([]EnergyMeterSensorDataResponse, 0)
+
+	if interval_ms == 0 {
+		for _, data := range sensorDataCompressed {
+			dataConverted := ConvertCompressedPayloadToSensorDataResponse(data.PayloadVersion, data.Payload)
+			if dataConverted == nil {
+				fmt.Printf("Failed to convert compressed payload to sensor data response for row %d\n", data.ID)
+				continue
+			}
+			sensorData = append(sensorData, *dataConverted)
+		}
+		return sensorData, nil
+	}
+
+	count, interval, err := workers.AggregateBuckets(interval_ms, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	sensorData = make([]EnergyMeterSensorDataResponse, count)
+	sums := make([]energySums, len(sensorData))
+
+	for _, data := range sensorDataCompressed {
+		index := int(data.CreatedAt.Sub(startTime) / interval)
+		if index < 0 || index >= len(sensorData) {
+			continue
+		}
+
+		dataConverted := ConvertCompressedPayloadToSensorDataResponse(data.PayloadVersion, data.Payload)
+		if dataConverted == nil {
+			fmt.Printf("Failed to convert compressed payload to sensor data response for row %d\n", data.ID)
+			continue
+		}
+
+		bucket := &sensorData[index]
+		bucket.PayloadVersion = dataConverted.PayloadVersion
+		bucket.EnergyKWh = dataConverted.EnergyKWh
+		if dataConverted.RelayOn != nil {
+			bucket.RelayOn = dataConverted.RelayOn
+		}
+		sums[index].power.add(dataConverted.PowerW)
+		sums[index].voltage.add(dataConverted.VoltageV)
+		sums[index].current.add(dataConverted.CurrentA)
+	}
+
+	for i, sum := range sums {
+		sensorData[i].PowerW = sum.power.average()
+		sensorData[i].VoltageV = sum.voltage.average()
+		sensorData[i].CurrentA = sum.current.average()
+	}
+
+	return sensorData, nil
+}
+
+type energySums struct {
+	power, voltage, current average
+}
+
+// average skips the readings without the sensor
+type average struct {
+	sum   float64
+	count int
+}
+
+func (a *average) add(value *float64) {
+	if value == nil {
+		return
+	}
+	a.sum += *value
+	a.count++
+}
+
+func (a *average) average() *float64 {
+	if a.count == 0 {
+		return nil
+	}
+	value := a.sum / float64(a.count)
+	return &value
+}
+
+// EnergyUsage sums the increases of the cumulative counter per day or month.
+// A counter lower than the previous reading means the plug was reset, so its
+// whole value counts as consumed since the reset.
+func (em *EnergyMeterWorker) EnergyUsage(rows []database.SensorData, period workers.EnergyPeriod, location *time.Location) ([]workers.EnergyUsage, error) {
+	usage := make([]workers.EnergyUsage, 0)
+
+	var previous *float64
+	for _, row := range rows {
+		data := ConvertCompressedPayloadToSensorDataResponse(row.PayloadVersion, row.Payload)
+		if data == nil {
+			fmt.Printf("Failed to convert compressed payload to sensor data response for row %d\n", row.ID)
+			continue
+		}
+
+		counter := data.EnergyKWh
+		if previous == nil {
+			previous = &counter
+			continue
+		}
+
+		consumed := counter - *previous
+		if consumed < 0 {
+			consumed = counter
+		}
+		*previous = counter
+
+		start := period.Start(row.CreatedAt, location)
+		if len(usage) == 0 || !usage[len(usage)-1].Start.Equal(start) {
+			usage = append(usage, workers.EnergyUsage{Start: start})
+		}
+		usage[len(usage)-1].EnergyKWh += consumed
+		usage[len(usage)-1].Readings++
+	}
+
+	return usage, nil
+}
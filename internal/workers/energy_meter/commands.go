@@ -0,0 +1,75 @@
+package energy_meter_worker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	em_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/energy_meter/payloads/energy_meter_payload_v1"
+)
+
+type SetRelayArgs struct {
+	On *bool `json:"on"`
+}
+
+func (em *EnergyMeterWorker) CommandTopic() string {
+	return EnergyMeterCommandTopic
+}
+
+func (em *EnergyMeterWorker) AckTopics() []string {
+	return []string{"energy-meter/acks", "energy-meter/+/acks"}
+}
+
+func (em *EnergyMeterWorker) BuildCommand(fuseID string, sequence int, command string, args json.RawMessage) (string, error) {
+	cmd, err := em_payload_v1.ParseCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", workers.ErrInvalidCommand, err)
+	}
+
+	wireArgs, err := BuildCommandArgs(cmd, args)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", workers.ErrInvalidCommand, err)
+	}
+
+	return em_payload_v1.CreateCommandWithSequence(em.client.ClientId(), fuseID, sequence, cmd, wireArgs), nil
+}
+
+func (em *EnergyMeterWorker) ParseAck(payload []byte) (*workers.CommandAck, error) {
+	ack, err := em_payload_v1.ParseAck(string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return &workers.CommandAck{
+		FuseID:   ack.FuseId,
+		Sequence: ack.Sequence,
+		Success:  ack.Success,
+		Message:  ack.Message,
+	}, nil
+}
+
+// BuildCommandArgs validates the JSON arguments of a command and converts them
+// to the positional arguments of the wire format.
+func BuildCommandArgs(command em_payload_v1.Command, args json.RawMessage) ([]string, error) {
+	switch command {
+	case em_payload_v1.CommandToggleRelay, em_payload_v1.CommandRestartDevice:
+		return []string{}, nil
+	case em_payload_v1.CommandSetRelay:
+		var relay SetRelayArgs
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing command arguments")
+		}
+		if err := json.Unmarshal(args, &relay); err != nil {
+			return nil, fmt.Errorf("invalid command arguments: %v", err)
+		}
+		if relay.On == nil {
+			return nil, fmt.Errorf("%s requires on", command)
+		}
+		if *relay.On {
+			return []string{"on"}, nil
+		}
+		return []string{"off"}, nil
+	default:
+		return nil, fmt.Errorf("unsupported command: %s", command)
+	}
+}
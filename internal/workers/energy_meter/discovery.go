@@ -0,0 +1,51 @@
+package energy_meter_worker
+
+import (
+	"encoding/json"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	em_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/energy_meter/payloads/energy_meter_payload_v1"
+)
+
+func (em *EnergyMeterWorker) DiscoveryEntities() []workers.DiscoveryEntity {
+	return []workers.DiscoveryEntity{
+		{Key: "power", Name: "Power", Component: workers.DiscoverySensor, DeviceClass: "power", Unit: "W", StateClass: "measurement"},
+		{Key: "voltage", Name: "Voltage", Component: workers.DiscoverySensor, DeviceClass: "voltage", Unit: "V", StateClass: "measurement"},
+		{Key: "current", Name: "Current", Component: workers.DiscoverySensor, DeviceClass: "current", Unit: "A", StateClass: "measurement"},
+		{Key: "energy", Name: "Energy", Component: workers.DiscoverySensor, DeviceClass: "energy", Unit: "kWh", StateClass: "total_increasing"},
+		{
+			Key:       "relay",
+			Name:      "Relay",
+			Component: workers.DiscoverySwitch,
+			Icon:      "mdi:power-socket",
+			SwitchCommand: func(on bool) (string, json.RawMessage) {
+				args, _ := json.Marshal(SetRelayArgs{On: &on})
+				return string(em_payload_v1.CommandSetRelay), args
+			},
+		},
+	}
+}
+
+func (em *EnergyMeterWorker) DiscoveryState(reading *workers.Reading) map[string]any {
+	data, ok := reading.Data.(em_payload_v1.Data)
+	if !ok {
+		return nil
+	}
+
+	// Sensors the device did not report are left out so Home Assistant keeps
+	// their last state
+	state := map[string]any{"energy": data.Sensors.EnergyKWh}
+	if data.Sensors.PowerW != nil {
+		state["power"] = *data.Sensors.PowerW
+	}
+	if data.Sensors.VoltageV != nil {
+		state["voltage"] = *data.Sensors.VoltageV
+	}
+	if data.Sensors.CurrentA != nil {
+		state["current"] = *data.Sensors.CurrentA
+	}
+	if data.Sensors.RelayOn != nil {
+		state["relay"] = *data.Sensors.RelayOn
+	}
+	return state
+}
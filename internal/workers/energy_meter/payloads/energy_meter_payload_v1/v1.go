@@ -0,0 +1,245 @@
+package energy_meter_payload_v1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Commands List
+// Must be appended with: payloadVersion;clientId;ESP.fuseMac;action:parameters
+// List of actions:parameters bellow
+//
+// set_relay:on/off
+// toggle_relay
+// restart_device
+//
+// Commands sent through the command endpoint append ";seq:<id>" so the device
+// can acknowledge them on energy-meter/acks with:
+// payloadVersion;ESP.fuseMac;seq;ok|error[;message]
+// Over MQTT 5 the ack may instead be published to the response topic of the
+// command, echoing its correlation data.
+//
+// Payload example:
+//
+// String payload = String(MQTT_MESSAGE_VERSION) + ";";
+// payload += String(clientId) + ";";
+// payload += String(ESP.getEfuseMac()) + ";";
+// payload += "p:" + String(powerW) + ";";
+// payload += "v:" + String(voltageV) + ";";
+// payload += "c:" + String(currentA) + ";";
+// payload += "e:" + String(energyKWh) + ";";
+// payload += "r:" + String(relayOn ? 1 : 0) + ";";
+// payload += "health:" +
+//     String(WiFi.RSSI()) + ":" + String(batteryPercent) + ":" +
+//     String(FIRMWARE_VERSION) + ":" + String(millis() / 1000);
+
+type Command string
+
+const (
+	CommandSetRelay      Command = "set_relay"
+	CommandToggleRelay   Command = "toggle_relay"
+	CommandRestartDevice Command = "restart_device"
+)
+
+var Commands = []Command{
+	CommandSetRelay,
+	CommandToggleRelay,
+	CommandRestartDevice,
+}
+
+func ParseCommand(command string) (Command, error) {
+	for _, known := range Commands {
+		if string(known) == command {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown command: %s", command)
+}
+
+type Payload struct {
+	Version  int    `json:"version"`
+	ClientId string `json:"clientId"`
+	FuseId   string `json:"espFuseId"`
+	Data     Data   `json:"data"`
+}
+
+type Data struct {
+	Sensors SensorData `json:"sensors"`
+}
+
+const MAX_COMPRESSED_PAYLOAD_LENGTH = 128
+
+// SensorData holds nil for the optional sensors the device did not report
+type SensorData struct {
+	PowerW   *float64 `json:"powerW"`
+	VoltageV *float64 `json:"voltageV"`
+	CurrentA *float64 `json:"currentA"`
+	// EnergyKWh is the cumulative counter of the plug, reset when the device
+	// loses its storage
+	EnergyKWh float64 `json:"energyKWh"`
+	RelayOn   *bool   `json:"relayOn"`
+}
+
+func ParsePayload(parts []string) (*Payload, error) {
+	var message Payload
+
+	message.ClientId = parts[1]
+	message.FuseId = parts[2]
+
+	hasEnergy := false
+	for i := 3; i < len(parts); i++ {
+		values := strings.Split(parts[i], ":")
+		key := values[0]
+		if key == "health" {
+			continue
+		}
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid energy meter data format: %s", parts[i])
+		}
+
+		value, err := strconv.ParseFloat(values[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid energy meter %s value: %s", key, values[1])
+		}
+
+		switch key {
+		case "p":
+			message.Data.Sensors.PowerW = &value
+		case "v":
+			message.Data.Sensors.VoltageV = &value
+		case "c":
+			message.Data.Sensors.CurrentA = &value
+		case "e":
+			if value < 0 {
+				return nil, fmt.Errorf("invalid energy meter energy value: %s", values[1])
+			}
+			message.Data.Sensors.EnergyKWh = value
+			hasEnergy = true
+		case "r":
+			relayOn := value != 0
+			message.Data.Sensors.RelayOn = &relayOn
+		}
+	}
+
+	if !hasEnergy {
+		return nil, fmt.Errorf("missing energy meter energy value")
+	}
+
+	return &message, nil
+}
+
+func CreateCommand(clientId string, fuseId string, command Command, args []string) string {
+	return fmt.Sprintf("1;%s;%s;%s:%s", clientId, fuseId, command, strings.Join(args, ","))
+}
+
+func CreateCommandWithSequence(clientId string, fuseId string, sequence int, command Command, args []string) string {
+	return fmt.Sprintf("%s;seq:%d", CreateCommand(clientId, fuseId, command, args), sequence)
+}
+
+type Ack struct {
+	FuseId   string
+	Sequence int
+	Success  bool
+	Message  string
+}
+
+func ParseAck(payload string) (*Ack, error) {
+	parts := strings.SplitN(payload, ";", 5)
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("invalid ack format: %s", payload)
+	}
+
+	if parts[0] != "1" {
+		return nil, fmt.Errorf("unsupported ack version: %s", parts[0])
+	}
+
+	sequence, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ack sequence: %s", parts[2])
+	}
+
+	ack := &Ack{
+		FuseId:   parts[1],
+		Sequence: sequence,
+	}
+
+	switch parts[3] {
+	case "ok":
+		ack.Success = true
+	case "error":
+		ack.Success = false
+	default:
+		return nil, fmt.Errorf("invalid ack status: %s", parts[3])
+	}
+
+	if len(parts) == 5 {
+		ack.Message = parts[4]
+	}
+
+	return ack, nil
+}
+
+// CompressDataToDatabase leaves out the sensors the device did not report, so
+// they decode as nil again.
+func CompressDataToDatabase(data Data) (string, error) {
+	var sections []string
+	if data.Sensors.PowerW != nil {
+		sections = append(sections, fmt.Sprintf("p:%.1f", *data.Sensors.PowerW))
+	}
+	if data.Sensors.VoltageV != nil {
+		sections = append(sections, fmt.Sprintf("v:%.1f", *data.Sensors.VoltageV))
+	}
+	if data.Sensors.CurrentA != nil {
+		sections = append(sections, fmt.Sprintf("c:%.3f", *data.Sensors.CurrentA))
+	}
+	sections = append(sections, fmt.Sprintf("e:%.3f", data.Sensors.EnergyKWh))
+	if data.Sensors.RelayOn != nil {
+		relay := 0
+		if *data.Sensors.RelayOn {
+			relay = 1
+		}
+		sections = append(sections, fmt.Sprintf("r:%d", relay))
+	}
+
+	compressedData := strings.Join(sections, ";")
+	if len(compressedData) > MAX_COMPRESSED_PAYLOAD_LENGTH {
+		fmt.Printf("Warning: Compressed data length %d exceeds maximum of %d characters\n", len(compressedData), MAX_COMPRESSED_PAYLOAD_LENGTH)
+		return "", fmt.Errorf("compressed data exceeds maximum length of %d characters", MAX_COMPRESSED_PAYLOAD_LENGTH)
+	}
+	return compressedData, nil
+}
+
+func DecompressDataFromDatabase(compressedData string) (Data, error) {
+	var data Data
+
+	for part := range strings.SplitSeq(compressedData, ";") {
+		values := strings.Split(part, ":")
+		if len(values) != 2 {
+			return data, fmt.Errorf("invalid energy meter data format: %s", part)
+		}
+
+		value, err := strconv.ParseFloat(values[1], 64)
+		if err != nil {
+			return data, fmt.Errorf("invalid energy meter %s value: %s", values[0], values[1])
+		}
+
+		switch values[0] {
+		case "p":
+			data.Sensors.PowerW = &value
+		case "v":
+			data.Sensors.VoltageV = &value
+		case "c":
+			data.Sensors.CurrentA = &value
+		case "e":
+			data.Sensors.EnergyKWh = value
+		case "r":
+			relayOn := value != 0
+			data.Sensors.RelayOn = &relayOn
+		default:
+			return data, fmt.Errorf("unknown data key: %s", values[0])
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,146 @@
+package energy_meter_payload_v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	em_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/energy_meter/payloads/energy_meter_payload_v1"
+)
+
+// Payload v2 is a JSON document. Version, fuseId and the energy counter are
+// required, the timestamp and health sections are optional.
+//
+// Payload example:
+//
+// {
+//   "version": 2,
+//   "clientId": "grow-lights-plug",
+//   "fuseId": "1287318723677812632",
+//   "timestamp": 1760731200,
+//   "sensors": { "powerW": 182.4, "voltageV": 229.8, "currentA": 0.794, "energyKWh": 412.337, "relayOn": true },
+//   "health": { "wifiStrength": -61, "batteryPercent": 100, "firmwareVersion": "1.0.0", "uptimeSeconds": 3600 }
+// }
+
+const Version = 2
+
+type Payload struct {
+	Version   *int     `json:"version"`
+	ClientId  string   `json:"clientId"`
+	FuseId    *string  `json:"fuseId"`
+	Timestamp *int64   `json:"timestamp"`
+	Sensors   *Sensors `json:"sensors"`
+	Health    *Health  `json:"health"`
+}
+
+type Sensors struct {
+	PowerW    *float64 `json:"powerW"`
+	VoltageV  *float64 `json:"voltageV"`
+	CurrentA  *float64 `json:"currentA"`
+	EnergyKWh *float64 `json:"energyKWh"`
+	RelayOn   *bool    `json:"relayOn"`
+}
+
+type Health struct {
+	WifiStrength    *int   `json:"wifiStrength"`
+	BatteryPercent  *int   `json:"batteryPercent"`
+	FirmwareVersion string `json:"firmwareVersion"`
+	UptimeSeconds   *int64 `json:"uptimeSeconds"`
+}
+
+func ParsePayload(payload []byte) (*Payload, error) {
+	var message Payload
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, fmt.Errorf("invalid energy meter v2 JSON: %w", err)
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid energy meter v2 payload: %w", err)
+	}
+
+	return &message, nil
+}
+
+// Validate checks the required fields and value ranges, reporting every
+// problem at once.
+func (p *Payload) Validate() error {
+	var errs []error
+
+	switch {
+	case p.Version == nil:
+		errs = append(errs, errors.New(`missing required field "version"`))
+	case *p.Version != Version:
+		errs = append(errs, fmt.Errorf(`field "version" must be %d, got %d`, Version, *p.Version))
+	}
+
+	switch {
+	case p.FuseId == nil || *p.FuseId == "":
+		errs = append(errs, errors.New(`missing required field "fuseId"`))
+	default:
+		// The fuse id is stored in a BIGINT column.
+		if _, err := strconv.ParseUint(*p.FuseId, 10, 63); err != nil {
+			errs = append(errs, fmt.Errorf(`field "fuseId" must be an unsigned decimal integer, got %q`, *p.FuseId))
+		}
+	}
+
+	if p.Timestamp != nil && *p.Timestamp <= 0 {
+		errs = append(errs, errors.New(`field "timestamp" must be a positive unix timestamp in seconds`))
+	}
+
+	if p.Sensors == nil {
+		errs = append(errs, errors.New(`missing required field "sensors"`))
+	} else {
+		switch {
+		case p.Sensors.EnergyKWh == nil:
+			errs = append(errs, errors.New(`missing required field "sensors.energyKWh"`))
+		case *p.Sensors.EnergyKWh < 0:
+			errs = append(errs, errors.New(`field "sensors.energyKWh" must not be negative`))
+		}
+		if p.Sensors.VoltageV != nil && *p.Sensors.VoltageV < 0 {
+			errs = append(errs, errors.New(`field "sensors.voltageV" must not be negative`))
+		}
+		if p.Sensors.CurrentA != nil && *p.Sensors.CurrentA < 0 {
+			errs = append(errs, errors.New(`field "sensors.currentA" must not be negative`))
+		}
+	}
+
+	if p.Health != nil {
+		if p.Health.WifiStrength == nil {
+			errs = append(errs, errors.New(`missing required field "health.wifiStrength"`))
+		}
+		if p.Health.BatteryPercent == nil {
+			errs = append(errs, errors.New(`missing required field "health.batteryPercent"`))
+		} else if *p.Health.BatteryPercent < 0 || *p.Health.BatteryPercent > 100 {
+			errs = append(errs, errors.New(`field "health.batteryPercent" must be between 0 and 100`))
+		}
+		if p.Health.UptimeSeconds != nil && *p.Health.UptimeSeconds < 0 {
+			errs = append(errs, errors.New(`field "health.uptimeSeconds" must not be negative`))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DeviceTimestamp returns when the device took the reading, or nil when the
+// message carries no timestamp.
+func (p *Payload) DeviceTimestamp() *time.Time {
+	if p.Timestamp == nil {
+		return nil
+	}
+	timestamp := time.Unix(*p.Timestamp, 0).UTC()
+	return &timestamp
+}
+
+// Data converts the payload to the v1 data stored in sensor_data. Missing
+// optional sensors stay nil and are left out of the stored row.
+func (p *Payload) Data() em_payload_v1.Data {
+	return em_payload_v1.Data{Sensors: em_payload_v1.SensorData{
+		PowerW:    p.Sensors.PowerW,
+		VoltageV:  p.Sensors.VoltageV,
+		CurrentA:  p.Sensors.CurrentA,
+		EnergyKWh: *p.Sensors.EnergyKWh,
+		RelayOn:   p.Sensors.RelayOn,
+	}}
+}
@@ -0,0 +1,46 @@
+package energy_meter_worker
+
+import (
+	"fmt"
+
+	em_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/energy_meter/payloads/energy_meter_payload_v1"
+)
+
+// EnergyMeterSensorData holds null for the sensors the device did not report
+type EnergyMeterSensorData struct {
+	PowerW    *float64 `json:"power_w"`
+	VoltageV  *float64 `json:"voltage_v"`
+	CurrentA  *float64 `json:"current_a"`
+	EnergyKWh float64  `json:"energy_kwh"`
+	RelayOn   *bool    `json:"relay_on"`
+}
+
+type EnergyMeterSensorDataResponse struct {
+	PayloadVersion int `json:"v"`
+	EnergyMeterSensorData
+}
+
+func ConvertCompressedPayloadToSensorDataResponse(payloadVersion int, payload string) *EnergyMeterSensorDataResponse {
+	switch payloadVersion {
+	case EnergyMeterMessageV1:
+		data, err := em_payload_v1.DecompressDataFromDatabase(payload)
+		if err != nil {
+			fmt.Printf("Failed to decompress Energy Meter v1 data: %v\n", err)
+			return nil
+		}
+
+		return &EnergyMeterSensorDataResponse{
+			PayloadVersion: EnergyMeterMessageV1,
+			EnergyMeterSensorData: EnergyMeterSensorData{
+				PowerW:    data.Sensors.PowerW,
+				VoltageV:  data.Sensors.VoltageV,
+				CurrentA:  data.Sensors.CurrentA,
+				EnergyKWh: data.Sensors.EnergyKWh,
+				RelayOn:   data.Sensors.RelayOn,
+			},
+		}
+	default:
+		fmt.Printf("Unsupported Energy Meter message version: %d\n", payloadVersion)
+		return nil
+	}
+}
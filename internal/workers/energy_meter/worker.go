@@ -0,0 +1,137 @@
+package energy_meter_worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/services"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	em_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/energy_meter/payloads/energy_meter_payload_v1"
+	em_payload_v2 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/energy_meter/payloads/energy_meter_payload_v2"
+)
+
+const EnergyMeterTopicID = 2
+const EnergyMeterCommandTopic = "energy-meter/commands"
+
+const DeviceName = "Energy Meter"
+const DeviceDescription = "Smart Plug Energy Meter Device"
+const DeviceType = "energy-meter"
+
+// List of suported Paylaods versions
+const (
+	EnergyMeterMessageV1 = 1
+	// V2 is JSON, detected by the leading '{'
+	EnergyMeterMessageV2 = 2
+)
+
+type EnergyMeterWorker struct {
+	client *services.MQTTClient
+}
+
+func NewEnergyMeterWorker(client *services.MQTTClient) *EnergyMeterWorker {
+	return &EnergyMeterWorker{
+		client: client,
+	}
+}
+
+func (em *EnergyMeterWorker) Info() workers.DeviceInfo {
+	return workers.DeviceInfo{
+		Type:        DeviceType,
+		Name:        DeviceName,
+		Description: DeviceDescription,
+		TopicID:     EnergyMeterTopicID,
+	}
+}
+
+func (em *EnergyMeterWorker) Topics() []string {
+	return []string{"energy-meter/sensors", "energy-meter/+/sensors"}
+}
+
+func (em *EnergyMeterWorker) Parse(payload []byte) (*workers.Reading, error) {
+	if len(payload) > 0 && payload[0] == '{' {
+		message, err := em_payload_v2.ParsePayload(payload)
+		if err != nil {
+			return nil, err
+		}
+		return readingFromV2(message), nil
+	}
+
+	var parts = strings.Split(string(payload), ";")
+
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid energy meter message format: %s", payload)
+	}
+
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid energy meter message version: %s", parts[0])
+	}
+
+	switch version {
+	case EnergyMeterMessageV1:
+		message, err := em_payload_v1.ParsePayload(parts)
+		if err != nil {
+			return nil, err
+		}
+		health, err := workers.ParseHealthSection(parts[3:])
+		if err != nil {
+			return nil, err
+		}
+		return &workers.Reading{
+			FuseID:         message.FuseId,
+			ClientID:       message.ClientId,
+			PayloadVersion: version,
+			Data:           message.Data,
+			Health:         health,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported energy meter message version: %d", version)
+	}
+}
+
+func (em *EnergyMeterWorker) Encode(reading *workers.Reading) (int, string, error) {
+	data, ok := reading.Data.(em_payload_v1.Data)
+	if !ok {
+		return 0, "", fmt.Errorf("unexpected energy meter data type %T", reading.Data)
+	}
+
+	compressedData, err := em_payload_v1.CompressDataToDatabase(data)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return EnergyMeterMessageV1, compressedData, nil
+}
+
+func (em *EnergyMeterWorker) Decode(payloadVersion int, payload string) (any, error) {
+	response := ConvertCompressedPayloadToSensorDataResponse(payloadVersion, payload)
+	if response == nil {
+		return nil, fmt.Errorf("failed to decode energy meter payload version %d", payloadVersion)
+	}
+	return response, nil
+}
+
+func readingFromV2(message *em_payload_v2.Payload) *workers.Reading {
+	reading := &workers.Reading{
+		FuseID:          *message.FuseId,
+		ClientID:        message.ClientId,
+		PayloadVersion:  EnergyMeterMessageV2,
+		Data:            message.Data(),
+		DeviceTimestamp: message.DeviceTimestamp(),
+	}
+
+	if health := message.Health; health != nil {
+		reading.Health = &database.DeviceHealth{
+			WifiStrength:    *health.WifiStrength,
+			BatteryPercent:  *health.BatteryPercent,
+			FirmwareVersion: health.FirmwareVersion,
+		}
+		if health.UptimeSeconds != nil {
+			reading.Health.UptimeSeconds = *health.UptimeSeconds
+		}
+	}
+
+	return reading
+}
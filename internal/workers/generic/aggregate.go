@@ -0,0 +1,110 @@
+package generic_worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+// bucket accumulates the rows of one interval using the field aggregations.
+// Severities keep the worst value of the interval.
+type bucket struct {
+	values     map[string]float64
+	counts     map[string]int
+	severities map[string]int
+}
+
+func newBucket() *bucket {
+	return &bucket{
+		values:     make(map[string]float64),
+		counts:     make(map[string]int),
+		severities: make(map[string]int),
+	}
+}
+
+func (b *bucket) add(fields []Field, values Values) {
+	for _, field := range fields {
+		value, exists := values.Values[field.Key]
+		if !exists {
+			continue
+		}
+
+		current, seen := b.values[field.Key]
+		switch {
+		case !seen:
+			b.values[field.Key] = value
+		case field.Aggregation == AggregateMin:
+			b.values[field.Key] = min(current, value)
+		case field.Aggregation == AggregateMax:
+			b.values[field.Key] = max(current, value)
+		case field.Aggregation == AggregateLast:
+			b.values[field.Key] = value
+		default:
+			b.values[field.Key] = current + value
+		}
+		b.counts[field.Key]++
+
+		if severity, exists := values.Severities[field.Key]; exists {
+			b.severities[field.Key] = max(b.severities[field.Key], severity)
+		}
+	}
+}
+
+func (b *bucket) result(fields []Field) Values {
+	result := Values{Values: b.values, Severities: b.severities}
+	for _, field := range fields {
+		if field.Aggregation == AggregateAvg && b.counts[field.Key] > 0 {
+			result.Values[field.Key] = b.values[field.Key] / float64(b.counts[field.Key])
+		}
+	}
+	return result
+}
+
+func (g *GenericWorker) Aggregate(sensorDataCompressed []database.SensorData, interval_ms int, startTime, endTime time.Time) (any, error) {
+	sensorData := make([]map[string]any, 0)
+
+	if interval_ms == 0 {
+		for _, data := range sensorDataCompressed {
+			values, err := g.decompress(data.PayloadVersion, data.Payload)
+			if err != nil {
+				fmt.Printf("Failed to decompress %s payload for row %d: %v\n", g.definition.Type, data.ID, err)
+				continue
+			}
+			sensorData = append(sensorData, g.response(data.PayloadVersion, values))
+		}
+		return sensorData, nil
+	}
+
+	count, interval, err := workers.AggregateBuckets(interval_ms, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]*bucket, count)
+	for i := range buckets {
+		buckets[i] = newBucket()
+	}
+
+	for _, data := range sensorDataCompressed {
+		index := int(data.CreatedAt.Sub(startTime) / interval)
+		if index < 0 || index >= len(buckets) {
+			continue
+		}
+
+		values, err := g.decompress(data.PayloadVersion, data.Payload)
+		if err != nil {
+			fmt.Printf("Failed to decompress %s payload for row %d: %v\n", g.definition.Type, data.ID, err)
+			continue
+		}
+		buckets[index].add(g.definition.Fields, values)
+	}
+
+	for i, bucket := range buckets {
+		response := g.response(GenericStorageV1, bucket.result(g.definition.Fields))
+		response["timestamp"] = startTime.Add(time.Duration(i) * interval)
+		sensorData = append(sensorData, response)
+	}
+
+	return sensorData, nil
+}
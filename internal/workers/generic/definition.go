@@ -0,0 +1,161 @@
+package generic_worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type FieldType string
+
+const (
+	FieldFloat FieldType = "float"
+	FieldInt   FieldType = "int"
+	FieldBool  FieldType = "bool"
+)
+
+type Aggregation string
+
+const (
+	AggregateAvg  Aggregation = "avg"
+	AggregateMin  Aggregation = "min"
+	AggregateMax  Aggregation = "max"
+	AggregateSum  Aggregation = "sum"
+	AggregateLast Aggregation = "last"
+)
+
+// Definition describes a device type handled by the generic worker. See
+// devices/examples for a complete file.
+type Definition struct {
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	TopicID     int      `json:"topicId"`
+	Topics      []string `json:"topics"`
+	Fields      []Field  `json:"fields"`
+}
+
+type Field struct {
+	// Key is the name of the field in the device payload and API responses
+	Key string `json:"key"`
+	// StorageKey is the shorter key used in sensor_data, defaults to Key
+	StorageKey string    `json:"storageKey"`
+	Type       FieldType `json:"type"`
+	Unit       string    `json:"unit"`
+	Required   bool      `json:"required"`
+	// SeverityKey names the companion field carrying the device severity
+	SeverityKey string      `json:"severityKey"`
+	Aggregation Aggregation `json:"aggregation"`
+}
+
+// LoadDefinitions reads every *.json file of dir. Sub directories, such as
+// the examples, are not loaded.
+func LoadDefinitions(dir string) ([]Definition, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list device definitions in %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	definitions := make([]Definition, 0, len(paths))
+	for _, path := range paths {
+		definition, err := LoadDefinition(path)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, *definition)
+	}
+
+	return definitions, nil
+}
+
+func LoadDefinition(path string) (*Definition, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device definition %s: %w", path, err)
+	}
+
+	var definition Definition
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&definition); err != nil {
+		return nil, fmt.Errorf("failed to parse device definition %s: %w", path, err)
+	}
+
+	if err := definition.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid device definition %s: %w", path, err)
+	}
+
+	return &definition, nil
+}
+
+// Validate checks the definition and fills the defaults of its fields.
+func (d *Definition) Validate() error {
+	if d.Type == "" {
+		return fmt.Errorf("missing type")
+	}
+	if d.Name == "" {
+		d.Name = d.Type
+	}
+	if d.TopicID < 0 {
+		return fmt.Errorf("topicId must not be negative")
+	}
+	if len(d.Topics) == 0 {
+		return fmt.Errorf("at least one topic is required")
+	}
+	if len(d.Fields) == 0 {
+		return fmt.Errorf("at least one field is required")
+	}
+
+	keys := make(map[string]bool)
+	storageKeys := make(map[string]bool)
+	for i := range d.Fields {
+		field := &d.Fields[i]
+
+		if field.Key == "" {
+			return fmt.Errorf("field %d is missing its key", i)
+		}
+		if field.StorageKey == "" {
+			field.StorageKey = field.Key
+		}
+		if strings.ContainsAny(field.Key+field.StorageKey, ":;") {
+			return fmt.Errorf("field %s keys must not contain ':' or ';'", field.Key)
+		}
+		if keys[field.Key] || keys[field.SeverityKey] || field.Key == field.SeverityKey {
+			return fmt.Errorf("duplicate field key %s", field.Key)
+		}
+		if storageKeys[field.StorageKey] {
+			return fmt.Errorf("duplicate field storage key %s", field.StorageKey)
+		}
+		keys[field.Key] = true
+		storageKeys[field.StorageKey] = true
+		if field.SeverityKey != "" {
+			keys[field.SeverityKey] = true
+		}
+
+		switch field.Type {
+		case FieldFloat, FieldInt, FieldBool:
+		case "":
+			field.Type = FieldFloat
+		default:
+			return fmt.Errorf("field %s has unsupported type %s", field.Key, field.Type)
+		}
+
+		switch field.Aggregation {
+		case AggregateAvg, AggregateMin, AggregateMax, AggregateSum, AggregateLast:
+		case "":
+			field.Aggregation = AggregateAvg
+			if field.Type == FieldBool {
+				field.Aggregation = AggregateLast
+			}
+		default:
+			return fmt.Errorf("field %s has unsupported aggregation %s", field.Key, field.Aggregation)
+		}
+	}
+
+	return nil
+}
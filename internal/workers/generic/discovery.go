@@ -0,0 +1,36 @@
+package generic_worker
+
+import (
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+// DiscoveryEntities exposes every field, booleans as binary sensors.
+func (g *GenericWorker) DiscoveryEntities() []workers.DiscoveryEntity {
+	entities := make([]workers.DiscoveryEntity, 0, len(g.definition.Fields))
+	for _, field := range g.definition.Fields {
+		entity := workers.DiscoveryEntity{
+			Key:        field.Key,
+			Name:       field.Key,
+			Component:  workers.DiscoverySensor,
+			Unit:       field.Unit,
+			StateClass: "measurement",
+		}
+		if field.Type == FieldBool {
+			entity.Component = workers.DiscoveryBinarySensor
+			entity.StateClass = ""
+		}
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+func (g *GenericWorker) DiscoveryState(reading *workers.Reading) map[string]any {
+	values, ok := reading.Data.(Values)
+	if !ok {
+		return nil
+	}
+
+	state := g.response(reading.PayloadVersion, values)
+	delete(state, "v")
+	return state
+}
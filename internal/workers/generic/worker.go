@@ -0,0 +1,376 @@
+package generic_worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+// List of suported Paylaods versions
+const (
+	// V1 is the text format: 1;clientId;fuseId;<key>:<value>;...;health:...
+	GenericMessageV1 = 1
+	// V2 is JSON, detected by the leading '{'
+	GenericMessageV2 = 2
+)
+
+// GenericStorageV1 stores <storageKey>:<value>[:<severity>] sections joined by ';'
+const GenericStorageV1 = 1
+
+const MAX_COMPRESSED_PAYLOAD_LENGTH = 128
+
+// Values holds the fields of a reading by key. Severities only has the fields
+// whose severity companion was sent.
+type Values struct {
+	Values     map[string]float64
+	Severities map[string]int
+}
+
+// GenericWorker handles a device type described by a Definition, so new
+// devices only need a definition file instead of a worker package.
+type GenericWorker struct {
+	definition Definition
+	byKey      map[string]*Field
+}
+
+func NewGenericWorker(definition Definition) (*GenericWorker, error) {
+	if err := definition.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s definition: %w", definition.Type, err)
+	}
+
+	worker := &GenericWorker{
+		definition: definition,
+		byKey:      make(map[string]*Field, len(definition.Fields)),
+	}
+	for i := range worker.definition.Fields {
+		field := &worker.definition.Fields[i]
+		worker.byKey[field.Key] = field
+	}
+
+	return worker, nil
+}
+
+func (g *GenericWorker) Definition() Definition {
+	return g.definition
+}
+
+func (g *GenericWorker) Info() workers.DeviceInfo {
+	return workers.DeviceInfo{
+		Type:        g.definition.Type,
+		Name:        g.definition.Name,
+		Description: g.definition.Description,
+		TopicID:     g.definition.TopicID,
+	}
+}
+
+func (g *GenericWorker) Topics() []string {
+	return g.definition.Topics
+}
+
+func (g *GenericWorker) Parse(payload []byte) (*workers.Reading, error) {
+	if len(payload) > 0 && payload[0] == '{' {
+		return g.parseJSON(payload)
+	}
+
+	parts := strings.Split(string(payload), ";")
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid %s message format: %s", g.definition.Type, payload)
+	}
+
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s message version: %s", g.definition.Type, parts[0])
+	}
+	if version != GenericMessageV1 {
+		return nil, fmt.Errorf("unsupported %s message version: %d", g.definition.Type, version)
+	}
+	if parts[2] == "" {
+		return nil, fmt.Errorf("missing %s fuse id", g.definition.Type)
+	}
+
+	health, err := workers.ParseHealthSection(parts[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	sensors := make(map[string]json.RawMessage)
+	for _, part := range parts[3:] {
+		key, value, found := strings.Cut(part, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid %s section: %s", g.definition.Type, part)
+		}
+		if key == "health" {
+			continue
+		}
+		// Booleans are sent as true/false, everything else is a number
+		if _, err := strconv.ParseBool(value); err != nil {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s value for %s: %s", g.definition.Type, key, value)
+			}
+		}
+		sensors[key] = json.RawMessage(value)
+	}
+
+	values, err := g.values(sensors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &workers.Reading{
+		FuseID:         parts[2],
+		ClientID:       parts[1],
+		PayloadVersion: version,
+		Data:           values,
+		Health:         health,
+	}, nil
+}
+
+// jsonPayload mirrors the v2 payloads of the built in workers.
+type jsonPayload struct {
+	ClientId  string                     `json:"clientId"`
+	FuseId    string                     `json:"fuseId"`
+	Timestamp *int64                     `json:"timestamp"`
+	Sensors   map[string]json.RawMessage `json:"sensors"`
+	Health    *struct {
+		WifiStrength    int    `json:"wifiStrength"`
+		BatteryPercent  int    `json:"batteryPercent"`
+		FirmwareVersion string `json:"firmwareVersion"`
+		UptimeSeconds   int64  `json:"uptimeSeconds"`
+	} `json:"health"`
+}
+
+func (g *GenericWorker) parseJSON(payload []byte) (*workers.Reading, error) {
+	var message jsonPayload
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, fmt.Errorf("invalid %s JSON: %w", g.definition.Type, err)
+	}
+
+	if message.FuseId == "" {
+		return nil, fmt.Errorf(`invalid %s payload: missing required field "fuseId"`, g.definition.Type)
+	}
+	if message.Timestamp != nil && *message.Timestamp <= 0 {
+		return nil, fmt.Errorf(`invalid %s payload: field "timestamp" must be a positive unix timestamp in seconds`, g.definition.Type)
+	}
+
+	values, err := g.values(message.Sensors)
+	if err != nil {
+		return nil, err
+	}
+
+	reading := &workers.Reading{
+		FuseID:         message.FuseId,
+		ClientID:       message.ClientId,
+		PayloadVersion: GenericMessageV2,
+		Data:           values,
+	}
+	if message.Timestamp != nil {
+		timestamp := time.Unix(*message.Timestamp, 0).UTC()
+		reading.DeviceTimestamp = &timestamp
+	}
+	if health := message.Health; health != nil {
+		reading.Health = &database.DeviceHealth{
+			WifiStrength:    health.WifiStrength,
+			BatteryPercent:  health.BatteryPercent,
+			FirmwareVersion: health.FirmwareVersion,
+			UptimeSeconds:   health.UptimeSeconds,
+		}
+	}
+
+	return reading, nil
+}
+
+// values converts the sensor fields using the definition types, reporting
+// every problem at once. Unknown keys are ignored.
+func (g *GenericWorker) values(sensors map[string]json.RawMessage) (Values, error) {
+	values := Values{
+		Values:     make(map[string]float64),
+		Severities: make(map[string]int),
+	}
+
+	var errs []error
+	for _, field := range g.definition.Fields {
+		raw, exists := sensors[field.Key]
+		if !exists || string(raw) == "null" {
+			if field.Required {
+				errs = append(errs, fmt.Errorf("missing required field %q", field.Key))
+			}
+			continue
+		}
+
+		value, err := parseValue(field.Type, raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("field %q: %w", field.Key, err))
+			continue
+		}
+		values.Values[field.Key] = value
+
+		if field.SeverityKey == "" {
+			continue
+		}
+		raw, exists = sensors[field.SeverityKey]
+		if !exists || string(raw) == "null" {
+			continue
+		}
+		severity, err := strconv.Atoi(string(raw))
+		if err != nil || severity < workers.SeverityNormal || severity > workers.SeverityCritical {
+			errs = append(errs, fmt.Errorf("field %q must be a severity between %d and %d", field.SeverityKey, workers.SeverityNormal, workers.SeverityCritical))
+			continue
+		}
+		values.Severities[field.Key] = severity
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return Values{}, fmt.Errorf("invalid %s payload: %w", g.definition.Type, err)
+	}
+
+	return values, nil
+}
+
+func parseValue(fieldType FieldType, raw json.RawMessage) (float64, error) {
+	switch fieldType {
+	case FieldBool:
+		value, err := strconv.ParseBool(string(raw))
+		if err != nil {
+			return 0, fmt.Errorf("must be a boolean")
+		}
+		if value {
+			return 1, nil
+		}
+		return 0, nil
+	case FieldInt:
+		value, err := strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("must be an integer")
+		}
+		return float64(value), nil
+	default:
+		value, err := strconv.ParseFloat(string(raw), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, fmt.Errorf("must be a number")
+		}
+		return value, nil
+	}
+}
+
+func (g *GenericWorker) Encode(reading *workers.Reading) (int, string, error) {
+	values, ok := reading.Data.(Values)
+	if !ok {
+		return 0, "", fmt.Errorf("unexpected %s data type %T", g.definition.Type, reading.Data)
+	}
+
+	sections := make([]string, 0, len(values.Values))
+	for _, field := range g.definition.Fields {
+		value, exists := values.Values[field.Key]
+		if !exists {
+			continue
+		}
+
+		section := field.StorageKey + ":" + strconv.FormatFloat(value, 'f', -1, 64)
+		if severity, exists := values.Severities[field.Key]; exists {
+			section += ":" + strconv.Itoa(severity)
+		}
+		sections = append(sections, section)
+	}
+
+	compressed := strings.Join(sections, ";")
+	if len(compressed) > MAX_COMPRESSED_PAYLOAD_LENGTH {
+		return 0, "", fmt.Errorf("compressed %s payload exceeds %d characters: %d", g.definition.Type, MAX_COMPRESSED_PAYLOAD_LENGTH, len(compressed))
+	}
+
+	return GenericStorageV1, compressed, nil
+}
+
+func (g *GenericWorker) decompress(payloadVersion int, payload string) (Values, error) {
+	if payloadVersion != GenericStorageV1 {
+		return Values{}, fmt.Errorf("unsupported %s storage version: %d", g.definition.Type, payloadVersion)
+	}
+
+	values := Values{
+		Values:     make(map[string]float64),
+		Severities: make(map[string]int),
+	}
+	if payload == "" {
+		return values, nil
+	}
+
+	for _, section := range strings.Split(payload, ";") {
+		parts := strings.Split(section, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return Values{}, fmt.Errorf("invalid %s stored section: %s", g.definition.Type, section)
+		}
+
+		// Fields removed from the definition are skipped
+		field := g.byStorageKey(parts[0])
+		if field == nil {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return Values{}, fmt.Errorf("invalid %s stored value: %s", g.definition.Type, section)
+		}
+		values.Values[field.Key] = value
+
+		if len(parts) == 3 {
+			severity, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return Values{}, fmt.Errorf("invalid %s stored severity: %s", g.definition.Type, section)
+			}
+			values.Severities[field.Key] = severity
+		}
+	}
+
+	return values, nil
+}
+
+func (g *GenericWorker) byStorageKey(storageKey string) *Field {
+	for i := range g.definition.Fields {
+		if g.definition.Fields[i].StorageKey == storageKey {
+			return &g.definition.Fields[i]
+		}
+	}
+	return nil
+}
+
+func (g *GenericWorker) Decode(payloadVersion int, payload string) (any, error) {
+	values, err := g.decompress(payloadVersion, payload)
+	if err != nil {
+		return nil, err
+	}
+	return g.response(payloadVersion, values), nil
+}
+
+// response builds the API object, keyed like the device payload with values
+// converted back to the field types.
+func (g *GenericWorker) response(payloadVersion int, values Values) map[string]any {
+	response := map[string]any{"v": payloadVersion}
+
+	for _, field := range g.definition.Fields {
+		value, exists := values.Values[field.Key]
+		if !exists {
+			continue
+		}
+
+		switch field.Type {
+		case FieldBool:
+			response[field.Key] = value != 0
+		case FieldInt:
+			response[field.Key] = int64(math.Round(value))
+		default:
+			response[field.Key] = value
+		}
+
+		if severity, exists := values.Severities[field.Key]; exists && field.SeverityKey != "" {
+			response[field.SeverityKey] = severity
+		}
+	}
+
+	return response
+}
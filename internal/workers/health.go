@@ -0,0 +1,48 @@
+package workers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+// ParseHealthSection looks for the optional health section shared by every
+// text payload and returns nil when the device did not send it.
+//
+// Format: health:<wifiRssi>:<batteryPercent>:<firmwareVersion>:<uptimeSeconds>
+func ParseHealthSection(parts []string) (*database.DeviceHealth, error) {
+	for _, part := range parts {
+		values := strings.Split(part, ":")
+		if values[0] != "health" {
+			continue
+		}
+
+		if len(values) != 5 {
+			return nil, fmt.Errorf("invalid health data format: %s", part)
+		}
+
+		wifiStrength, err := strconv.Atoi(values[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid health wifi strength value: %s", values[1])
+		}
+		batteryPercent, err := strconv.Atoi(values[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid health battery percent value: %s", values[2])
+		}
+		uptimeSeconds, err := strconv.ParseInt(values[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health uptime value: %s", values[4])
+		}
+
+		return &database.DeviceHealth{
+			WifiStrength:    wifiStrength,
+			BatteryPercent:  batteryPercent,
+			FirmwareVersion: values[3],
+			UptimeSeconds:   uptimeSeconds,
+		}, nil
+	}
+
+	return nil, nil
+}
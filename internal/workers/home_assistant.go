@@ -0,0 +1,394 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/services"
+)
+
+const (
+	DefaultHomeAssistantDiscoveryPrefix = "homeassistant"
+	DefaultHomeAssistantStatePrefix     = "mqtt-home-server"
+
+	// HomeAssistantSender is stored as the sender of the commands sent by
+	// Home Assistant switches
+	HomeAssistantSender = "home-assistant"
+
+	homeAssistantManufacturer = "mqtt-home-server"
+	homeAssistantOn           = "ON"
+	homeAssistantOff          = "OFF"
+)
+
+type DiscoveryComponent string
+
+const (
+	DiscoverySensor       DiscoveryComponent = "sensor"
+	DiscoveryBinarySensor DiscoveryComponent = "binary_sensor"
+	DiscoverySwitch       DiscoveryComponent = "switch"
+)
+
+// DiscoveryEntity is a Home Assistant entity of a device type. Key is the
+// field of the normalized state holding its value.
+type DiscoveryEntity struct {
+	Key         string
+	Name        string
+	Component   DiscoveryComponent
+	DeviceClass string
+	Unit        string
+	StateClass  string
+	Icon        string
+	// SwitchCommand returns the command and arguments turning a switch on or
+	// off
+	SwitchCommand func(on bool) (string, json.RawMessage)
+	// SwitchToggles is set when SwitchCommand toggles the state, so it is only
+	// sent when the requested state differs from the last reported one and
+	// refused while that state is unknown
+	SwitchToggles bool
+}
+
+// DiscoveryWorker is implemented by device workers exposed to Home Assistant.
+type DiscoveryWorker interface {
+	DiscoveryEntities() []DiscoveryEntity
+	// DiscoveryState normalizes a reading into values keyed by entity key
+	DiscoveryState(reading *Reading) map[string]any
+}
+
+// StoredDiscoveryWorker rebuilds the discovery state from a stored sensor_data
+// row, resolving toggling switches not reported since the worker started.
+type StoredDiscoveryWorker interface {
+	StoredDiscoveryState(payloadVersion int, payload string) (map[string]any, error)
+}
+
+type HomeAssistantConfig struct {
+	DiscoveryPrefix string
+	// StatePrefix is the root of the state and switch command topics
+	StatePrefix string
+}
+
+// HomeAssistantDiscovery publishes retained Home Assistant discovery configs
+// for every stored device and a normalized state topic per device, and turns
+// switch commands into device commands.
+//
+// Topics:
+//
+//	<discoveryPrefix>/<component>/<fuseId>/<key>/config
+//	<statePrefix>/<fuseId>/state
+//	<statePrefix>/<fuseId>/<key>/set
+type HomeAssistantDiscovery struct {
+	db       *database.Database
+	client   *services.MQTTClient
+	registry *Registry
+	config   HomeAssistantConfig
+
+	// announced holds the devices whose configs were published since the
+	// last broker connection, switchStates the last reported switch states
+	announced    map[string]bool
+	switchStates map[string]bool
+	mu           sync.Mutex
+}
+
+func NewHomeAssistantDiscovery(db *database.Database, client *services.MQTTClient, registry *Registry, config HomeAssistantConfig) *HomeAssistantDiscovery {
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = DefaultHomeAssistantDiscoveryPrefix
+	}
+	if config.StatePrefix == "" {
+		config.StatePrefix = DefaultHomeAssistantStatePrefix
+	}
+
+	return &HomeAssistantDiscovery{
+		db:           db,
+		client:       client,
+		registry:     registry,
+		config:       config,
+		announced:    make(map[string]bool),
+		switchStates: make(map[string]bool),
+	}
+}
+
+// Register hooks the publisher into the registry and MQTT client. It must be
+// called before the MQTT client starts.
+func (h *HomeAssistantDiscovery) Register() error {
+	h.registry.OnReading(h.onReading)
+
+	// Published in the background so the connect callback is not held
+	h.client.OnConnect(func() {
+		go h.announceAll(context.Background())
+	})
+
+	// Home Assistant drops the discovered entities when it restarts without
+	// a persistent broker session, so they are announced again on its birth
+	// message. Every replica must receive it.
+	statusTopic := h.config.DiscoveryPrefix + "/status"
+	err := h.client.SubscribeExclusive(statusTopic, 1, func(msg mqtt.Message) {
+		if string(msg.Payload()) == services.StatusOnline {
+			go h.announceAll(context.Background())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", statusTopic, err)
+	}
+
+	commandTopic := h.config.StatePrefix + "/+/+/set"
+	if err := h.client.Subscribe(commandTopic, h.commandHandler); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", commandTopic, err)
+	}
+
+	return nil
+}
+
+func (h *HomeAssistantDiscovery) announceAll(ctx context.Context) {
+	h.mu.Lock()
+	h.announced = make(map[string]bool)
+	h.mu.Unlock()
+
+	devices, err := h.db.DeviceRepository().GetDevices(ctx)
+	if err != nil {
+		fmt.Printf("Failed to list devices for Home Assistant discovery: %v\n", err)
+		return
+	}
+
+	for _, device := range devices {
+		worker, exists := h.registry.ByDeviceType(device.Type)
+		if !exists {
+			continue
+		}
+		if err := h.announce(ctx, &device, worker); err != nil {
+			fmt.Printf("Failed to announce device %s to Home Assistant: %v\n", device.FuseID, err)
+		}
+	}
+}
+
+// announce publishes the discovery configs of a device, doing nothing for
+// device types without Home Assistant entities.
+func (h *HomeAssistantDiscovery) announce(ctx context.Context, device *database.Device, worker DeviceWorker) error {
+	discoveryWorker, ok := worker.(DiscoveryWorker)
+	if !ok {
+		return nil
+	}
+
+	for _, entity := range discoveryWorker.DiscoveryEntities() {
+		config, err := json.Marshal(h.entityConfig(device, worker.Info(), entity))
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s config: %w", entity.Key, err)
+		}
+
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", h.config.DiscoveryPrefix, entity.Component, device.FuseID, entity.Key)
+		if err := h.client.Publish(ctx, topic, config, 1, true); err != nil {
+			return fmt.Errorf("failed to publish %s config: %w", entity.Key, err)
+		}
+	}
+
+	h.mu.Lock()
+	h.announced[device.FuseID] = true
+	h.mu.Unlock()
+
+	fmt.Printf("Announced device %s to Home Assistant\n", device.FuseID)
+	return nil
+}
+
+type homeAssistantDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+}
+
+type homeAssistantEntityConfig struct {
+	Name          string              `json:"name"`
+	UniqueID      string              `json:"unique_id"`
+	ObjectID      string              `json:"object_id"`
+	StateTopic    string              `json:"state_topic"`
+	ValueTemplate string              `json:"value_template"`
+	CommandTopic  string              `json:"command_topic,omitempty"`
+	DeviceClass   string              `json:"device_class,omitempty"`
+	Unit          string              `json:"unit_of_measurement,omitempty"`
+	StateClass    string              `json:"state_class,omitempty"`
+	Icon          string              `json:"icon,omitempty"`
+	Device        homeAssistantDevice `json:"device"`
+}
+
+func (h *HomeAssistantDiscovery) entityConfig(device *database.Device, info DeviceInfo, entity DiscoveryEntity) homeAssistantEntityConfig {
+	objectID := fmt.Sprintf("%s_%s", device.FuseID, entity.Key)
+
+	// Metrics absent from a reading are left out of the state, the templates
+	// keep the current state for them
+	config := homeAssistantEntityConfig{
+		Name:          entity.Name,
+		UniqueID:      objectID,
+		ObjectID:      objectID,
+		StateTopic:    h.stateTopic(device.FuseID),
+		ValueTemplate: fmt.Sprintf("{{ value_json.%s if '%s' in value_json else this.state }}", entity.Key, entity.Key),
+		DeviceClass:   entity.DeviceClass,
+		Unit:          entity.Unit,
+		StateClass:    entity.StateClass,
+		Icon:          entity.Icon,
+		Device: homeAssistantDevice{
+			Identifiers:  []string{fmt.Sprintf("%s_%s", homeAssistantManufacturer, device.FuseID)},
+			Name:         fmt.Sprintf("%s %s", device.Name, device.FuseID),
+			Model:        info.Name,
+			Manufacturer: homeAssistantManufacturer,
+			SwVersion:    device.FirmwareVersion,
+		},
+	}
+
+	if entity.Component == DiscoveryBinarySensor || entity.Component == DiscoverySwitch {
+		config.ValueTemplate = fmt.Sprintf("{{ ('%s' if value_json.%s else '%s') if '%s' in value_json else this.state | upper }}", homeAssistantOn, entity.Key, homeAssistantOff, entity.Key)
+	}
+	if entity.Component == DiscoverySwitch {
+		config.CommandTopic = fmt.Sprintf("%s/%s/%s/set", h.config.StatePrefix, device.FuseID, entity.Key)
+	}
+
+	return config
+}
+
+func (h *HomeAssistantDiscovery) stateTopic(fuseID string) string {
+	return fmt.Sprintf("%s/%s/state", h.config.StatePrefix, fuseID)
+}
+
+// onReading announces devices seen for the first time, including the ones
+// just created by the registry, and publishes the normalized state.
+func (h *HomeAssistantDiscovery) onReading(device *database.Device, worker DeviceWorker, reading *Reading) {
+	discoveryWorker, ok := worker.(DiscoveryWorker)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+
+	h.mu.Lock()
+	announced := h.announced[device.FuseID]
+	h.mu.Unlock()
+
+	if !announced {
+		if err := h.announce(ctx, device, worker); err != nil {
+			fmt.Printf("Failed to announce device %s to Home Assistant: %v\n", device.FuseID, err)
+		}
+	}
+
+	state := discoveryWorker.DiscoveryState(reading)
+	if state == nil {
+		return
+	}
+
+	h.mu.Lock()
+	for _, entity := range discoveryWorker.DiscoveryEntities() {
+		if on, ok := state[entity.Key].(bool); ok && entity.Component == DiscoverySwitch {
+			h.switchStates[device.FuseID+"/"+entity.Key] = on
+		}
+	}
+	h.mu.Unlock()
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		fmt.Printf("Failed to marshal Home Assistant state of device %s: %v\n", device.FuseID, err)
+		return
+	}
+
+	if err := h.client.Publish(ctx, h.stateTopic(device.FuseID), payload, 1, true); err != nil {
+		fmt.Printf("Failed to publish Home Assistant state of device %s: %v\n", device.FuseID, err)
+	}
+}
+
+// commandHandler sends the device command of a switch turned on or off in
+// Home Assistant.
+func (h *HomeAssistantDiscovery) commandHandler(msg mqtt.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), h.config.StatePrefix+"/"), "/")
+	if len(parts) != 3 || parts[2] != "set" {
+		fmt.Printf("Ignoring Home Assistant command on unexpected topic %s\n", msg.Topic())
+		return
+	}
+	fuseID, key := parts[0], parts[1]
+
+	var on bool
+	switch string(msg.Payload()) {
+	case homeAssistantOn:
+		on = true
+	case homeAssistantOff:
+		on = false
+	default:
+		fmt.Printf("Ignoring Home Assistant command %q for device %s\n", msg.Payload(), fuseID)
+		return
+	}
+
+	ctx := context.Background()
+	device, err := h.db.DeviceRepository().GetDeviceByFuseID(ctx, fuseID)
+	if err != nil {
+		fmt.Printf("Failed to get device %s for Home Assistant command: %v\n", fuseID, err)
+		return
+	}
+
+	worker, exists := h.registry.ByDeviceType(device.Type)
+	discoveryWorker, ok := worker.(DiscoveryWorker)
+	if !exists || !ok {
+		fmt.Printf("Device type %s has no Home Assistant entities\n", device.Type)
+		return
+	}
+
+	for _, entity := range discoveryWorker.DiscoveryEntities() {
+		if entity.Key != key || entity.Component != DiscoverySwitch || entity.SwitchCommand == nil {
+			continue
+		}
+
+		// Toggling from an unknown state could turn the switch the wrong way
+		if entity.SwitchToggles {
+			current, known := h.switchState(ctx, device, worker, key)
+			if !known {
+				fmt.Printf("Refusing Home Assistant %s command for device %s, its current state is unknown\n", key, fuseID)
+				return
+			}
+			if current == on {
+				return
+			}
+		}
+
+		command, args := entity.SwitchCommand(on)
+		if _, err := h.registry.SendCommand(ctx, device, command, args, HomeAssistantSender); err != nil {
+			fmt.Printf("Failed to send Home Assistant %s command to device %s: %v\n", key, fuseID, err)
+		}
+		return
+	}
+
+	fmt.Printf("Device %s has no Home Assistant switch %s\n", fuseID, key)
+}
+
+// switchState returns the last reported state of a switch, falling back to
+// the latest stored reading of the device.
+func (h *HomeAssistantDiscovery) switchState(ctx context.Context, device *database.Device, worker DeviceWorker, key string) (bool, bool) {
+	h.mu.Lock()
+	on, known := h.switchStates[device.FuseID+"/"+key]
+	h.mu.Unlock()
+	if known {
+		return on, true
+	}
+
+	storedWorker, ok := worker.(StoredDiscoveryWorker)
+	if !ok {
+		return false, false
+	}
+
+	row, err := h.db.SensorRepository().GetLastSensorDataBefore(ctx, device.ID, time.Now())
+	if err != nil {
+		fmt.Printf("Failed to get the latest reading of device %s: %v\n", device.FuseID, err)
+		return false, false
+	}
+	if row == nil {
+		return false, false
+	}
+
+	state, err := storedWorker.StoredDiscoveryState(row.PayloadVersion, row.Payload)
+	if err != nil {
+		fmt.Printf("Failed to decode the latest reading of device %s: %v\n", device.FuseID, err)
+		return false, false
+	}
+
+	on, known = state[key].(bool)
+	return on, known
+}
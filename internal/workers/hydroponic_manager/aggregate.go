@@ -0,0 +1,184 @@
+package hydroponic_manager_worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	hm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v1"
+)
+
+// SumData accumulates the values of a bucket, while the severities hold the
+// worst level seen since averaging an enum hides every warning. Counts holds
+// how many rows reported each metric, so absent metrics do not drag the
+// averages towards zero.
+type SumData struct {
+	Temperature          float32
+	TemperaturaSeverity  SeverityLevel
+	Moisture             float32
+	MoistureSeverity     SeverityLevel
+	Ph                   float32
+	PhSeverity           SeverityLevel
+	Conductivity         int
+	ConductivitySeverity SeverityLevel
+	Nitrogen             int
+	NitrogenSeverity     SeverityLevel
+	Phosphorus           int
+	PhosphorusSeverity   SeverityLevel
+	Potassium            int
+	PotassiumSeverity    SeverityLevel
+	WaterLevelCm         float32
+	ServerSeverity       *HydroponicManagerServerSeverity
+	Relay                *HydroponicManagerRelay
+	Counts               map[hm_payload_v1.Metric]int
+}
+
+// add counts the metric when the row reported it
+func (s *SumData) add(row *HydroponicManagerSensorDataResponse, metric hm_payload_v1.Metric) bool {
+	if row.absent&metric != 0 {
+		return false
+	}
+	if s.Counts == nil {
+		s.Counts = make(map[hm_payload_v1.Metric]int)
+	}
+	s.Counts[metric]++
+	return true
+}
+
+// addRow accumulates the metrics a row reported
+func (s *SumData) addRow(row *HydroponicManagerSensorDataResponse) {
+	if s.add(row, hm_payload_v1.MetricTemperature) {
+		s.Temperature += row.Temperature
+		s.TemperaturaSeverity = max(s.TemperaturaSeverity, row.TemperaturaSeverity)
+	}
+	if s.add(row, hm_payload_v1.MetricMoisture) {
+		s.Moisture += row.Moisture
+		s.MoistureSeverity = max(s.MoistureSeverity, row.MoistureSeverity)
+	}
+	if s.add(row, hm_payload_v1.MetricPh) {
+		s.Ph += row.Ph
+		s.PhSeverity = max(s.PhSeverity, row.PhSeverity)
+	}
+	if s.add(row, hm_payload_v1.MetricConductivity) {
+		s.Conductivity += row.Conductivity
+		s.ConductivitySeverity = max(s.ConductivitySeverity, row.ConductivitySeverity)
+	}
+	if s.add(row, hm_payload_v1.MetricNitrogen) {
+		s.Nitrogen += row.Nitrogen
+		s.NitrogenSeverity = max(s.NitrogenSeverity, row.NitrogenSeverity)
+	}
+	if s.add(row, hm_payload_v1.MetricPhosphorus) {
+		s.Phosphorus += row.Phosphorus
+		s.PhosphorusSeverity = max(s.PhosphorusSeverity, row.PhosphorusSeverity)
+	}
+	if s.add(row, hm_payload_v1.MetricPotassium) {
+		s.Potassium += row.Potassium
+		s.PotassiumSeverity = max(s.PotassiumSeverity, row.PotassiumSeverity)
+	}
+	if s.add(row, hm_payload_v1.MetricWaterLevel) {
+		s.WaterLevelCm += row.WaterLevelCm
+	}
+	if s.add(row, hm_payload_v1.MetricRelay) {
+		relay := row.HydroponicManagerRelay
+		s.Relay = &relay
+	}
+	s.ServerSeverity = WorstSeverity(s.ServerSeverity, row.ServerSeverity)
+}
+
+// result averages the bucket, metrics no row reported are missing from it
+func (s *SumData) result(bucket *HydroponicManagerSensorDataResponse) {
+	var absent hm_payload_v1.Metric
+	for _, metric := range metricNames {
+		if s.Counts[metric.metric] == 0 {
+			absent |= metric.metric
+		}
+	}
+
+	if n := s.Counts[hm_payload_v1.MetricTemperature]; n > 0 {
+		bucket.Temperature = s.Temperature / float32(n)
+		bucket.TemperaturaSeverity = s.TemperaturaSeverity
+	}
+	if n := s.Counts[hm_payload_v1.MetricMoisture]; n > 0 {
+		bucket.Moisture = s.Moisture / float32(n)
+		bucket.MoistureSeverity = s.MoistureSeverity
+	}
+	if n := s.Counts[hm_payload_v1.MetricPh]; n > 0 {
+		bucket.Ph = s.Ph / float32(n)
+		bucket.PhSeverity = s.PhSeverity
+	}
+	if n := s.Counts[hm_payload_v1.MetricConductivity]; n > 0 {
+		bucket.Conductivity = s.Conductivity / n
+		bucket.ConductivitySeverity = s.ConductivitySeverity
+	}
+	if n := s.Counts[hm_payload_v1.MetricNitrogen]; n > 0 {
+		bucket.Nitrogen = s.Nitrogen / n
+		bucket.NitrogenSeverity = s.NitrogenSeverity
+	}
+	if n := s.Counts[hm_payload_v1.MetricPhosphorus]; n > 0 {
+		bucket.Phosphorus = s.Phosphorus / n
+		bucket.PhosphorusSeverity = s.PhosphorusSeverity
+	}
+	if n := s.Counts[hm_payload_v1.MetricPotassium]; n > 0 {
+		bucket.Potassium = s.Potassium / n
+		bucket.PotassiumSeverity = s.PotassiumSeverity
+	}
+	if n := s.Counts[hm_payload_v1.MetricWaterLevel]; n > 0 {
+		bucket.WaterLevelCm = s.WaterLevelCm / float32(n)
+	}
+	if s.Relay != nil {
+		bucket.HydroponicManagerRelay = *s.Relay
+	}
+	bucket.ServerSeverity = s.ServerSeverity
+	bucket.setAbsent(absent)
+}
+
+// Aggregate averages the rows per interval, starting at startTime. The last
+// interval may be partial and intervals without readings have every metric
+// missing.
+func (hm *HydroponicManagerWorker) Aggregate(sensorDataCompressed []database.SensorData, interval_ms int, startTime, endTime time.Time) (any, error) {
+	sensorData := make([]HydroponicManagerSensorDataResponse, 0)
+
+	if interval_ms == 0 {
+		for _, data := range sensorDataCompressed {
+			dataConverted := ConvertCompressedPayloadToSensorDataResponse(data.PayloadVersion, data.Payload)
+			if dataConverted == nil {
+				fmt.Printf("Failed to convert compressed payload to sensor data response for row %d\n", data.ID)
+				continue
+			}
+			dataConverted.Timestamp = data.CreatedAt
+			sensorData = append(sensorData, *dataConverted)
+		}
+		return sensorData, nil
+	}
+
+	count, interval, err := workers.AggregateBuckets(interval_ms, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	sensorData = make([]HydroponicManagerSensorDataResponse, count)
+	sums := make([]SumData, count)
+
+	for _, data := range sensorDataCompressed {
+		index := int(data.CreatedAt.Sub(startTime) / interval)
+		if index < 0 || index >= len(sensorData) {
+			continue
+		}
+
+		dataConverted := ConvertCompressedPayloadToSensorDataResponse(data.PayloadVersion, data.Payload)
+		if dataConverted == nil {
+			fmt.Printf("Failed to convert compressed payload to sensor data response for row %d\n", data.ID)
+			continue
+		}
+
+		sensorData[index].PayloadVersion = dataConverted.PayloadVersion
+		sums[index].addRow(dataConverted)
+	}
+
+	for i := range sensorData {
+		sensorData[i].Timestamp = startTime.Add(time.Duration(i) * interval)
+		sums[i].result(&sensorData[i])
+	}
+
+	return sensorData, nil
+}
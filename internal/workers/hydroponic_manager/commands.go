@@ -0,0 +1,234 @@
+package hydroponic_manager_worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	hm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v1"
+)
+
+type ThresholdArgs struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+type PercentageThresholdArgs struct {
+	Warn     *float64 `json:"warn"`
+	Critical *float64 `json:"critical"`
+}
+
+type WifiCredentialsArgs struct {
+	SSID     string `json:"ssid"`
+	Password string `json:"password"`
+}
+
+type AutomaticConfigurationArgs struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type CropsIdsArgs struct {
+	Ids []int `json:"ids"`
+}
+
+// thresholdCommands maps the metrics stored in device_thresholds to the
+// command applying them. The percentage thresholds store warn as min and
+// critical as max.
+var thresholdCommands = map[string]hm_payload_v1.Command{
+	"nitrogen":     hm_payload_v1.CommandSetNitrogenThresholds,
+	"phosphorus":   hm_payload_v1.CommandSetPhosphorusThresholds,
+	"potassium":    hm_payload_v1.CommandSetPotassiumThresholds,
+	"ph":           hm_payload_v1.CommandSetPhThresholds,
+	"conductivity": hm_payload_v1.CommandSetConductivityThresholds,
+	"water_level":  hm_payload_v1.CommandSetWaterLevelThresholds,
+	"percentage":   hm_payload_v1.CommandSetPercentageThresholds,
+}
+
+func (hm *HydroponicManagerWorker) CommandTopic() string {
+	return HydroponicManagerCommandTopic
+}
+
+func (hm *HydroponicManagerWorker) AckTopics() []string {
+	return []string{"hydroponic-manager/acks", "hydroponic-manager/+/acks"}
+}
+
+func (hm *HydroponicManagerWorker) BuildCommand(fuseID string, sequence int, command string, args json.RawMessage) (string, error) {
+	cmd, err := hm_payload_v1.ParseCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", workers.ErrInvalidCommand, err)
+	}
+
+	wireArgs, err := BuildCommandArgs(cmd, args)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", workers.ErrInvalidCommand, err)
+	}
+
+	return hm_payload_v1.CreateCommandWithSequence(hm.client.ClientId(), fuseID, sequence, cmd, wireArgs), nil
+}
+
+func (hm *HydroponicManagerWorker) ParseAck(payload []byte) (*workers.CommandAck, error) {
+	ack, err := hm_payload_v1.ParseAck(string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return &workers.CommandAck{
+		FuseID:   ack.FuseId,
+		Sequence: ack.Sequence,
+		Success:  ack.Success,
+		Message:  ack.Message,
+	}, nil
+}
+
+// BuildCommandArgs validates the JSON arguments of a command and converts them
+// to the positional arguments of the wire format.
+func BuildCommandArgs(command hm_payload_v1.Command, args json.RawMessage) ([]string, error) {
+	switch command {
+	case hm_payload_v1.CommandToggleRelay, hm_payload_v1.CommandRestartDevice:
+		return []string{}, nil
+	case hm_payload_v1.CommandSetNitrogenThresholds,
+		hm_payload_v1.CommandSetPhosphorusThresholds,
+		hm_payload_v1.CommandSetPotassiumThresholds,
+		hm_payload_v1.CommandSetConductivityThresholds,
+		hm_payload_v1.CommandSetPhThresholds,
+		hm_payload_v1.CommandSetWaterLevelThresholds:
+		var thresholds ThresholdArgs
+		if err := decodeArgs(args, &thresholds); err != nil {
+			return nil, err
+		}
+		if thresholds.Min == nil || thresholds.Max == nil {
+			return nil, fmt.Errorf("%s requires min and max", command)
+		}
+		if *thresholds.Min >= *thresholds.Max {
+			return nil, fmt.Errorf("%s requires min to be lower than max", command)
+		}
+		if command == hm_payload_v1.CommandSetPhThresholds && (*thresholds.Min < 0 || *thresholds.Max > 14) {
+			return nil, fmt.Errorf("%s requires values between 0 and 14", command)
+		}
+		return []string{formatFloat(*thresholds.Min), formatFloat(*thresholds.Max)}, nil
+	case hm_payload_v1.CommandSetPercentageThresholds:
+		var thresholds PercentageThresholdArgs
+		if err := decodeArgs(args, &thresholds); err != nil {
+			return nil, err
+		}
+		if thresholds.Warn == nil || thresholds.Critical == nil {
+			return nil, fmt.Errorf("%s requires warn and critical", command)
+		}
+		if *thresholds.Warn < 0 || *thresholds.Critical > 100 {
+			return nil, fmt.Errorf("%s requires values between 0 and 100", command)
+		}
+		if *thresholds.Warn >= *thresholds.Critical {
+			return nil, fmt.Errorf("%s requires warn to be lower than critical", command)
+		}
+		return []string{formatFloat(*thresholds.Warn), formatFloat(*thresholds.Critical)}, nil
+	case hm_payload_v1.CommandSetWifiCredentials:
+		var credentials WifiCredentialsArgs
+		if err := decodeArgs(args, &credentials); err != nil {
+			return nil, err
+		}
+		if credentials.SSID == "" {
+			return nil, fmt.Errorf("%s requires an ssid", command)
+		}
+		if strings.ContainsAny(credentials.SSID+credentials.Password, ",;") {
+			return nil, fmt.Errorf("%s does not support ',' or ';' in the ssid or password", command)
+		}
+		return []string{credentials.SSID, credentials.Password}, nil
+	case hm_payload_v1.CommandSetAutomaticConfiguration:
+		var configuration AutomaticConfigurationArgs
+		if err := decodeArgs(args, &configuration); err != nil {
+			return nil, err
+		}
+		if configuration.Enabled == nil {
+			return nil, fmt.Errorf("%s requires enabled", command)
+		}
+		return []string{strconv.FormatBool(*configuration.Enabled)}, nil
+	case hm_payload_v1.CommandSetCropsIds:
+		var crops CropsIdsArgs
+		if err := decodeArgs(args, &crops); err != nil {
+			return nil, err
+		}
+		if len(crops.Ids) == 0 {
+			return nil, fmt.Errorf("%s requires at least one crop id", command)
+		}
+		ids := make([]string, 0, len(crops.Ids))
+		for _, id := range crops.Ids {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		return ids, nil
+	default:
+		return nil, fmt.Errorf("unsupported command: %s", command)
+	}
+}
+
+func decodeArgs(args json.RawMessage, target any) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command arguments")
+	}
+	if err := json.Unmarshal(args, target); err != nil {
+		return fmt.Errorf("invalid command arguments: %v", err)
+	}
+	return nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (hm *HydroponicManagerWorker) ThresholdMetrics() []string {
+	return []string{"nitrogen", "phosphorus", "potassium", "ph", "conductivity", "water_level", "percentage"}
+}
+
+func (hm *HydroponicManagerWorker) ThresholdCommand(metric string, min, max float64) (string, json.RawMessage, error) {
+	command, exists := thresholdCommands[metric]
+	if !exists {
+		return "", nil, fmt.Errorf("%w: unknown threshold metric %s", workers.ErrInvalidCommand, metric)
+	}
+
+	var args any = ThresholdArgs{Min: &min, Max: &max}
+	if command == hm_payload_v1.CommandSetPercentageThresholds {
+		args = PercentageThresholdArgs{Warn: &min, Critical: &max}
+	}
+
+	jsonArgs, err := json.Marshal(args)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(command), jsonArgs, nil
+}
+
+// RedactCommand hides the wifi password of set_wifi_credentials. The payload
+// is redacted in place so commands stored before the redaction are covered.
+func (hm *HydroponicManagerWorker) RedactCommand(command string, args json.RawMessage, payload string) (json.RawMessage, string, bool) {
+	if command != string(hm_payload_v1.CommandSetWifiCredentials) {
+		return args, payload, false
+	}
+
+	var credentials WifiCredentialsArgs
+	if err := json.Unmarshal(args, &credentials); err == nil {
+		credentials.Password = workers.RedactedValue
+		if redactedArgs, err := json.Marshal(credentials); err == nil {
+			args = redactedArgs
+		}
+	} else {
+		args = json.RawMessage(`{}`)
+	}
+
+	// The password is the second argument of the command section
+	prefix := command + ":"
+	parts := strings.Split(payload, ";")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, prefix) {
+			continue
+		}
+		values := strings.Split(strings.TrimPrefix(part, prefix), ",")
+		if len(values) > 1 {
+			values[1] = workers.RedactedValue
+		}
+		parts[i] = prefix + strings.Join(values, ",")
+	}
+
+	return args, strings.Join(parts, ";"), true
+}
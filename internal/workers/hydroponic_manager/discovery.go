@@ -0,0 +1,77 @@
+package hydroponic_manager_worker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	hm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v1"
+)
+
+func (hm *HydroponicManagerWorker) DiscoveryEntities() []workers.DiscoveryEntity {
+	return []workers.DiscoveryEntity{
+		{Key: "temperature", Name: "Temperature", Component: workers.DiscoverySensor, DeviceClass: "temperature", Unit: "°C", StateClass: "measurement"},
+		{Key: "moisture", Name: "Moisture", Component: workers.DiscoverySensor, DeviceClass: "moisture", Unit: "%", StateClass: "measurement"},
+		{Key: "ph", Name: "pH", Component: workers.DiscoverySensor, DeviceClass: "ph", StateClass: "measurement"},
+		{Key: "conductivity", Name: "EC", Component: workers.DiscoverySensor, DeviceClass: "conductivity", Unit: "µS/cm", StateClass: "measurement"},
+		{Key: "nitrogen", Name: "Nitrogen", Component: workers.DiscoverySensor, Unit: "mg/kg", StateClass: "measurement", Icon: "mdi:leaf"},
+		{Key: "phosphorus", Name: "Phosphorus", Component: workers.DiscoverySensor, Unit: "mg/kg", StateClass: "measurement", Icon: "mdi:leaf"},
+		{Key: "potassium", Name: "Potassium", Component: workers.DiscoverySensor, Unit: "mg/kg", StateClass: "measurement", Icon: "mdi:leaf"},
+		{Key: "water_level", Name: "Water Level", Component: workers.DiscoverySensor, DeviceClass: "distance", Unit: "cm", StateClass: "measurement", Icon: "mdi:waves"},
+		{
+			Key:       "relay",
+			Name:      "Relay",
+			Component: workers.DiscoverySwitch,
+			Icon:      "mdi:water-pump",
+			SwitchCommand: func(on bool) (string, json.RawMessage) {
+				return string(hm_payload_v1.CommandToggleRelay), nil
+			},
+			SwitchToggles: true,
+		},
+	}
+}
+
+func (hm *HydroponicManagerWorker) DiscoveryState(reading *workers.Reading) map[string]any {
+	data, ok := reading.Data.(hm_payload_v1.Data)
+	if !ok {
+		return nil
+	}
+
+	values := []struct {
+		key    string
+		metric hm_payload_v1.Metric
+		value  any
+	}{
+		{"temperature", hm_payload_v1.MetricTemperature, data.Sensors.Temperature},
+		{"moisture", hm_payload_v1.MetricMoisture, data.Sensors.Moisture},
+		{"ph", hm_payload_v1.MetricPh, data.Sensors.Ph},
+		{"conductivity", hm_payload_v1.MetricConductivity, data.Sensors.Conductivity},
+		{"nitrogen", hm_payload_v1.MetricNitrogen, data.Sensors.Nitrogen},
+		{"phosphorus", hm_payload_v1.MetricPhosphorus, data.Sensors.Phosphorus},
+		{"potassium", hm_payload_v1.MetricPotassium, data.Sensors.Potassium},
+		{"water_level", hm_payload_v1.MetricWaterLevel, data.Sensors.WaterLevelCm},
+		{"relay", hm_payload_v1.MetricRelay, data.Relay.IsOn},
+	}
+
+	// Absent metrics are left out so Home Assistant keeps their last state
+	state := make(map[string]any, len(values))
+	for _, value := range values {
+		if data.Has(value.metric) {
+			state[value.key] = value.value
+		}
+	}
+	return state
+}
+
+func (hm *HydroponicManagerWorker) StoredDiscoveryState(payloadVersion int, payload string) (map[string]any, error) {
+	switch payloadVersion {
+	case HydroponicManagerStorageV1, HydroponicManagerStorageV2, HydroponicManagerStorageV3:
+		data, err := hm_payload_v1.DecompressDataFromDatabase(payload)
+		if err != nil {
+			return nil, err
+		}
+		return hm.DiscoveryState(&workers.Reading{PayloadVersion: payloadVersion, Data: data}), nil
+	default:
+		return nil, fmt.Errorf("unsupported Hydroponic Manager storage version: %d", payloadVersion)
+	}
+}
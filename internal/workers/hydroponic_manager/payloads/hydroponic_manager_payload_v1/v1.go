@@ -23,6 +23,12 @@ import (
 // set_crops_ids:id1,id2,id3
 // restart_device
 //
+// Commands sent through the command endpoint append ";seq:<id>" so the device
+// can acknowledge them on hydroponic-manager/acks with:
+// payloadVersion;ESP.fuseMac;seq;ok|error[;message]
+// Over MQTT 5 the ack may instead be published to the response topic of the
+// command, echoing its correlation data.
+//
 // Payload example:
 //
 // String payload = String(MQTT_MESSAGE_VERSION) + ";";
@@ -39,7 +45,10 @@ import (
 //     String(sd.nitrogen) + ":" + static_cast<int>(sd.nitrogenSeverity) + ":" +
 //     String(sd.phosphorus) + ":" + static_cast<int>(sd.phosphorusSeverity) + ":" +
 //     String(sd.potassium) + ":" + static_cast<int>(sd.potassiumSeverity) + ";";
-// payload += "water_level:" + String(wl.levelCm);
+// payload += "water_level:" + String(wl.levelCm) + ";";
+// payload += "health:" +
+//     String(WiFi.RSSI()) + ":" + String(batteryPercent) + ":" +
+//     String(FIRMWARE_VERSION) + ":" + String(millis() / 1000);
 
 type Command string
 
@@ -58,6 +67,30 @@ const (
 	CommandRestartDevice             Command = "restart_device"
 )
 
+var Commands = []Command{
+	CommandToggleRelay,
+	CommandSetNitrogenThresholds,
+	CommandSetPhosphorusThresholds,
+	CommandSetPotassiumThresholds,
+	CommandSetPhThresholds,
+	CommandSetConductivityThresholds,
+	CommandSetWaterLevelThresholds,
+	CommandSetPercentageThresholds,
+	CommandSetWifiCredentials,
+	CommandSetAutomaticConfiguration,
+	CommandSetCropsIds,
+	CommandRestartDevice,
+}
+
+func ParseCommand(command string) (Command, error) {
+	for _, known := range Commands {
+		if string(known) == command {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown command: %s", command)
+}
+
 type Payload struct {
 	Version  int    `json:"version"`
 	ClientId string `json:"clientId"`
@@ -68,6 +101,38 @@ type Payload struct {
 type Data struct {
 	Sensors SensorData             `json:"sensors"`
 	Relay   HydroponicManagerRelay `json:"relay"`
+	// ServerSeverity is computed by the server from the configured thresholds,
+	// nil when the device has none
+	ServerSeverity *ServerSeverity `json:"serverSeverity,omitempty"`
+	// Absent marks the metrics the device did not report, their values are
+	// zero and must not be used
+	Absent Metric `json:"-"`
+}
+
+// Metric is a bit mask of the reported metrics, in ServerSeverity order
+// followed by the relay.
+type Metric uint16
+
+const (
+	MetricTemperature Metric = 1 << iota
+	MetricMoisture
+	MetricPh
+	MetricConductivity
+	MetricNitrogen
+	MetricPhosphorus
+	MetricPotassium
+	MetricWaterLevel
+	MetricRelay
+)
+
+// MetricSensors are the metrics reported by the "sensor" section of v1
+const MetricSensors = MetricTemperature | MetricMoisture | MetricPh | MetricConductivity | MetricNitrogen | MetricPhosphorus | MetricPotassium
+
+const MetricAll = MetricSensors | MetricWaterLevel | MetricRelay
+
+// Has reports whether the device reported the metric.
+func (d Data) Has(metric Metric) bool {
+	return d.Absent&metric == 0
 }
 
 type SeverityLevel int
@@ -80,6 +145,24 @@ const (
 	CRITICAL
 )
 
+// UNKNOWN is the server severity of a metric without a configured threshold
+const UNKNOWN SeverityLevel = -1
+
+type ServerSeverity struct {
+	Temperature  SeverityLevel `json:"temperatureSeverity"`
+	Moisture     SeverityLevel `json:"moistureSeverity"`
+	Ph           SeverityLevel `json:"phSeverity"`
+	Conductivity SeverityLevel `json:"conductivitySeverity"`
+	Nitrogen     SeverityLevel `json:"nitrogenSeverity"`
+	Phosphorus   SeverityLevel `json:"phosphorusSeverity"`
+	Potassium    SeverityLevel `json:"potassiumSeverity"`
+	WaterLevel   SeverityLevel `json:"waterLevelSeverity"`
+}
+
+func (s *ServerSeverity) levels() []*SeverityLevel {
+	return []*SeverityLevel{&s.Temperature, &s.Moisture, &s.Ph, &s.Conductivity, &s.Nitrogen, &s.Phosphorus, &s.Potassium, &s.WaterLevel}
+}
+
 type SensorData struct {
 	Temperature          float32       `json:"temperature"`
 	TemperaturaSeverity  SeverityLevel `json:"temperatureSeverity"`
@@ -95,6 +178,7 @@ type SensorData struct {
 	PhosphorusSeverity   SeverityLevel `json:"phosphorusSeverity"`
 	Potassium            int           `json:"potassium"`
 	PotassiumSeverity    SeverityLevel `json:"potassiumSeverity"`
+	WaterLevelCm         float32       `json:"waterLevelCm"`
 }
 
 type HydroponicManagerRelay struct {
@@ -108,6 +192,7 @@ func ParsePayload(parts []string) (*Payload, error) {
 	message.ClientId = parts[1]
 	message.FuseId = parts[2]
 
+	var reported Metric
 	for i := 3; i < len(parts); i++ {
 		var values = strings.Split(parts[i], ":")
 		var key = values[0]
@@ -171,6 +256,7 @@ func ParsePayload(parts []string) (*Payload, error) {
 			}
 
 			message.Data.Sensors = SensorData{
+				WaterLevelCm:         message.Data.Sensors.WaterLevelCm,
 				Temperature:          float32(temperature),
 				TemperaturaSeverity:  SeverityLevel(temperatureSeverity),
 				Moisture:             float32(moisture),
@@ -186,6 +272,20 @@ func ParsePayload(parts []string) (*Payload, error) {
 				Potassium:            potassium,
 				PotassiumSeverity:    SeverityLevel(potassiumSeverity),
 			}
+			reported |= MetricSensors
+			continue
+		}
+
+		if key == "water_level" {
+			if len(values) != 2 {
+				return nil, fmt.Errorf("invalid water level data format")
+			}
+			waterLevel, err := strconv.ParseFloat(values[1], 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid water level value: %s", values[1])
+			}
+			message.Data.Sensors.WaterLevelCm = float32(waterLevel)
+			reported |= MetricWaterLevel
 			continue
 		}
 
@@ -202,9 +302,11 @@ func ParsePayload(parts []string) (*Payload, error) {
 				IsOn:                isOn,
 				NextToggleInSeconds: nextToggleInSeconds,
 			}
+			reported |= MetricRelay
 			continue
 		}
 	}
+	message.Data.Absent = MetricAll &^ reported
 
 	return &message, nil
 }
@@ -213,17 +315,103 @@ func CreateCommand(clientId string, fuseId string, command Command, args []strin
 	return fmt.Sprintf("1;%s;%s;%s:%s", clientId, fuseId, command, strings.Join(args, ","))
 }
 
+func CreateCommandWithSequence(clientId string, fuseId string, sequence int, command Command, args []string) string {
+	return fmt.Sprintf("%s;seq:%d", CreateCommand(clientId, fuseId, command, args), sequence)
+}
+
+type Ack struct {
+	FuseId   string
+	Sequence int
+	Success  bool
+	Message  string
+}
+
+func ParseAck(payload string) (*Ack, error) {
+	parts := strings.SplitN(payload, ";", 5)
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("invalid ack format: %s", payload)
+	}
+
+	if parts[0] != "1" {
+		return nil, fmt.Errorf("unsupported ack version: %s", parts[0])
+	}
+
+	sequence, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ack sequence: %s", parts[2])
+	}
+
+	ack := &Ack{
+		FuseId:   parts[1],
+		Sequence: sequence,
+	}
+
+	switch parts[3] {
+	case "ok":
+		ack.Success = true
+	case "error":
+		ack.Success = false
+	default:
+		return nil, fmt.Errorf("invalid ack status: %s", parts[3])
+	}
+
+	if len(parts) == 5 {
+		ack.Message = parts[4]
+	}
+
+	return ack, nil
+}
+
+// CompressDataToDatabase writes the storage v2 format, which adds the water
+// level ("W") to v1. Rows stored as v1 decode with an absent water level. When
+// the server computed severities the storage v3 "S" section is appended, one
+// character per metric in ServerSeverity order with "-" for UNKNOWN. Absent
+// metrics are left out so they decode as absent again.
 func CompressDataToDatabase(data Data) (string, error) {
-	compressedData := fmt.Sprintf("T:%.2f:%d;M:%.2f:%d;pH:%.2f:%d;C:%d:%d;N:%d:%d;P:%d:%d;K:%d:%d;R:%t:%d",
-		data.Sensors.Temperature, data.Sensors.TemperaturaSeverity,
-		data.Sensors.Moisture, data.Sensors.MoistureSeverity,
-		data.Sensors.Ph, data.Sensors.PhSeverity,
-		data.Sensors.Conductivity, data.Sensors.ConductivitySeverity,
-		data.Sensors.Nitrogen, data.Sensors.NitrogenSeverity,
-		data.Sensors.Phosphorus, data.Sensors.PhosphorusSeverity,
-		data.Sensors.Potassium, data.Sensors.PotassiumSeverity,
-		data.Relay.IsOn, data.Relay.NextToggleInSeconds,
-	)
+	var sections []string
+	if data.Has(MetricTemperature) {
+		sections = append(sections, fmt.Sprintf("T:%.2f:%d", data.Sensors.Temperature, data.Sensors.TemperaturaSeverity))
+	}
+	if data.Has(MetricMoisture) {
+		sections = append(sections, fmt.Sprintf("M:%.2f:%d", data.Sensors.Moisture, data.Sensors.MoistureSeverity))
+	}
+	if data.Has(MetricPh) {
+		sections = append(sections, fmt.Sprintf("pH:%.2f:%d", data.Sensors.Ph, data.Sensors.PhSeverity))
+	}
+	if data.Has(MetricConductivity) {
+		sections = append(sections, fmt.Sprintf("C:%d:%d", data.Sensors.Conductivity, data.Sensors.ConductivitySeverity))
+	}
+	if data.Has(MetricNitrogen) {
+		sections = append(sections, fmt.Sprintf("N:%d:%d", data.Sensors.Nitrogen, data.Sensors.NitrogenSeverity))
+	}
+	if data.Has(MetricPhosphorus) {
+		sections = append(sections, fmt.Sprintf("P:%d:%d", data.Sensors.Phosphorus, data.Sensors.PhosphorusSeverity))
+	}
+	if data.Has(MetricPotassium) {
+		sections = append(sections, fmt.Sprintf("K:%d:%d", data.Sensors.Potassium, data.Sensors.PotassiumSeverity))
+	}
+	if data.Has(MetricRelay) {
+		sections = append(sections, fmt.Sprintf("R:%t:%d", data.Relay.IsOn, data.Relay.NextToggleInSeconds))
+	}
+	if data.Has(MetricWaterLevel) {
+		sections = append(sections, fmt.Sprintf("W:%.2f", data.Sensors.WaterLevelCm))
+	}
+	compressedData := strings.Join(sections, ";")
+
+	if data.ServerSeverity != nil {
+		var severities strings.Builder
+		for _, level := range data.ServerSeverity.levels() {
+			if *level == UNKNOWN {
+				severities.WriteByte('-')
+			} else {
+				severities.WriteString(strconv.Itoa(int(*level)))
+			}
+		}
+		if compressedData != "" {
+			compressedData += ";"
+		}
+		compressedData += "S:" + severities.String()
+	}
 
 	if len(compressedData) > MAX_COMPRESSED_PAYLOAD_LENGTH {
 		fmt.Printf("Warning: Compressed data length %d exceeds maximum of %d characters\n", len(compressedData), MAX_COMPRESSED_PAYLOAD_LENGTH)
@@ -234,8 +422,12 @@ func CompressDataToDatabase(data Data) (string, error) {
 
 func DecompressDataFromDatabase(compressedData string) (Data, error) {
 	var data Data
+	var reported Metric
 
 	for part := range strings.SplitSeq(compressedData, ";") {
+		if part == "" {
+			continue
+		}
 		values := strings.Split(part, ":")
 		key := values[0]
 
@@ -254,6 +446,7 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Sensors.Temperature = float32(temp)
 			data.Sensors.TemperaturaSeverity = SeverityLevel(severity)
+			reported |= MetricTemperature
 		case "M":
 			if len(values) != 3 {
 				return data, fmt.Errorf("invalid moisture data format")
@@ -268,6 +461,7 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Sensors.Moisture = float32(moisture)
 			data.Sensors.MoistureSeverity = SeverityLevel(severity)
+			reported |= MetricMoisture
 		case "pH":
 			if len(values) != 3 {
 				return data, fmt.Errorf("invalid pH data format")
@@ -282,6 +476,7 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Sensors.Ph = float32(ph)
 			data.Sensors.PhSeverity = SeverityLevel(severity)
+			reported |= MetricPh
 		case "C":
 			if len(values) != 3 {
 				return data, fmt.Errorf("invalid conductivity data format")
@@ -296,6 +491,7 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Sensors.Conductivity = conductivity
 			data.Sensors.ConductivitySeverity = SeverityLevel(severity)
+			reported |= MetricConductivity
 		case "N":
 			if len(values) != 3 {
 				return data, fmt.Errorf("invalid nitrogen data format")
@@ -310,6 +506,7 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Sensors.Nitrogen = nitrogen
 			data.Sensors.NitrogenSeverity = SeverityLevel(severity)
+			reported |= MetricNitrogen
 		case "P":
 			if len(values) != 3 {
 				return data, fmt.Errorf("invalid phosphorus data format")
@@ -324,6 +521,7 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Sensors.Phosphorus = phosphorus
 			data.Sensors.PhosphorusSeverity = SeverityLevel(severity)
+			reported |= MetricPhosphorus
 		case "K":
 			if len(values) != 3 {
 				return data, fmt.Errorf("invalid potassium data format")
@@ -338,6 +536,7 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Sensors.Potassium = potassium
 			data.Sensors.PotassiumSeverity = SeverityLevel(severity)
+			reported |= MetricPotassium
 		case "R":
 			if len(values) != 3 {
 				return data, fmt.Errorf("invalid relay data format")
@@ -352,12 +551,42 @@ func DecompressDataFromDatabase(compressedData string) (Data, error) {
 			}
 			data.Relay.IsOn = isOn
 			data.Relay.NextToggleInSeconds = nextToggleInSeconds
+			reported |= MetricRelay
+		case "W":
+			if len(values) != 2 {
+				return data, fmt.Errorf("invalid water level data format")
+			}
+			waterLevel, err := strconv.ParseFloat(values[1], 32)
+			if err != nil {
+				return data, fmt.Errorf("invalid water level value: %s", values[1])
+			}
+			data.Sensors.WaterLevelCm = float32(waterLevel)
+			reported |= MetricWaterLevel
+		case "S":
+			serverSeverity := &ServerSeverity{}
+			levels := serverSeverity.levels()
+			if len(values) != 2 || len(values[1]) != len(levels) {
+				return data, fmt.Errorf("invalid server severity data format")
+			}
+			for i, char := range values[1] {
+				if char == '-' {
+					*levels[i] = UNKNOWN
+					continue
+				}
+				severity, err := strconv.Atoi(string(char))
+				if err != nil {
+					return data, fmt.Errorf("invalid server severity value: %s", values[1])
+				}
+				*levels[i] = SeverityLevel(severity)
+			}
+			data.ServerSeverity = serverSeverity
 		default:
 			fmt.Printf("Warning: Unknown data key: %s\n", key)
 			fmt.Printf("Values for unknown key: %v\n", values)
 			return data, fmt.Errorf("unknown data key: %s", key)
 		}
 	}
+	data.Absent = MetricAll &^ reported
 
 	return data, nil
 }
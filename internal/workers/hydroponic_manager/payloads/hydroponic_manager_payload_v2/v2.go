@@ -0,0 +1,253 @@
+package hydroponic_manager_payload_v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	hm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v1"
+)
+
+// Payload v2 is a JSON document, so new sensors can be added without breaking
+// older parsers. Only version and fuseId are required, every other section
+// may be omitted. Sensors missing from a message are stored as absent.
+//
+// Payload example:
+//
+// {
+//   "version": 2,
+//   "clientId": "hydroponic-manager-01",
+//   "fuseId": "1287318723677812632",
+//   "timestamp": 1760731200,
+//   "sensors": {
+//     "moisture": { "value": 45.2, "severity": 0 },
+//     "temperature": { "value": 23.1, "severity": 0 },
+//     "conductivity": { "value": 1200, "severity": 1 },
+//     "ph": { "value": 6.2, "severity": 0 },
+//     "nitrogen": { "value": 120, "severity": 0 },
+//     "phosphorus": { "value": 40, "severity": 0 },
+//     "potassium": { "value": 180, "severity": 0 }
+//   },
+//   "relay": { "isOn": true, "nextToggleInSeconds": 120 },
+//   "waterLevelCm": 12.5,
+//   "health": { "wifiStrength": -61, "batteryPercent": 87, "firmwareVersion": "1.4.0", "uptimeSeconds": 3600 }
+// }
+
+const Version = 2
+
+type Payload struct {
+	Version      *int     `json:"version"`
+	ClientId     string   `json:"clientId"`
+	FuseId       *string  `json:"fuseId"`
+	Timestamp    *int64   `json:"timestamp"`
+	Sensors      *Sensors `json:"sensors"`
+	Relay        *Relay   `json:"relay"`
+	WaterLevelCm *float32 `json:"waterLevelCm"`
+	Health       *Health  `json:"health"`
+}
+
+type Measurement struct {
+	Value    *float64 `json:"value"`
+	Severity *int     `json:"severity"`
+}
+
+type Sensors struct {
+	Moisture     *Measurement `json:"moisture"`
+	Temperature  *Measurement `json:"temperature"`
+	Conductivity *Measurement `json:"conductivity"`
+	Ph           *Measurement `json:"ph"`
+	Nitrogen     *Measurement `json:"nitrogen"`
+	Phosphorus   *Measurement `json:"phosphorus"`
+	Potassium    *Measurement `json:"potassium"`
+}
+
+type Relay struct {
+	IsOn                *bool `json:"isOn"`
+	NextToggleInSeconds *int  `json:"nextToggleInSeconds"`
+}
+
+type Health struct {
+	WifiStrength    *int   `json:"wifiStrength"`
+	BatteryPercent  *int   `json:"batteryPercent"`
+	FirmwareVersion string `json:"firmwareVersion"`
+	UptimeSeconds   *int64 `json:"uptimeSeconds"`
+}
+
+func ParsePayload(payload []byte) (*Payload, error) {
+	var message Payload
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, fmt.Errorf("invalid Hydroponic Manager v2 JSON: %w", err)
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Hydroponic Manager v2 payload: %w", err)
+	}
+
+	return &message, nil
+}
+
+// Validate checks the required fields and value ranges, reporting every
+// problem at once.
+func (p *Payload) Validate() error {
+	var errs []error
+
+	switch {
+	case p.Version == nil:
+		errs = append(errs, errors.New(`missing required field "version"`))
+	case *p.Version != Version:
+		errs = append(errs, fmt.Errorf(`field "version" must be %d, got %d`, Version, *p.Version))
+	}
+
+	switch {
+	case p.FuseId == nil || *p.FuseId == "":
+		errs = append(errs, errors.New(`missing required field "fuseId"`))
+	default:
+		// The fuse id is stored in a BIGINT column.
+		if _, err := strconv.ParseUint(*p.FuseId, 10, 63); err != nil {
+			errs = append(errs, fmt.Errorf(`field "fuseId" must be an unsigned decimal integer, got %q`, *p.FuseId))
+		}
+	}
+
+	if p.Timestamp != nil && *p.Timestamp <= 0 {
+		errs = append(errs, errors.New(`field "timestamp" must be a positive unix timestamp in seconds`))
+	}
+
+	if p.Sensors != nil {
+		errs = append(errs, p.Sensors.Moisture.validate("sensors.moisture", 0, 100)...)
+		errs = append(errs, p.Sensors.Temperature.validate("sensors.temperature", -40, 85)...)
+		errs = append(errs, p.Sensors.Conductivity.validate("sensors.conductivity", 0, 20000)...)
+		errs = append(errs, p.Sensors.Ph.validate("sensors.ph", 0, 14)...)
+		errs = append(errs, p.Sensors.Nitrogen.validate("sensors.nitrogen", 0, 1999)...)
+		errs = append(errs, p.Sensors.Phosphorus.validate("sensors.phosphorus", 0, 1999)...)
+		errs = append(errs, p.Sensors.Potassium.validate("sensors.potassium", 0, 1999)...)
+	}
+
+	if p.Relay != nil {
+		if p.Relay.IsOn == nil {
+			errs = append(errs, errors.New(`missing required field "relay.isOn"`))
+		}
+		if p.Relay.NextToggleInSeconds != nil && *p.Relay.NextToggleInSeconds < 0 {
+			errs = append(errs, errors.New(`field "relay.nextToggleInSeconds" must not be negative`))
+		}
+	}
+
+	if p.WaterLevelCm != nil && *p.WaterLevelCm < 0 {
+		errs = append(errs, errors.New(`field "waterLevelCm" must not be negative`))
+	}
+
+	if p.Health != nil {
+		if p.Health.WifiStrength == nil {
+			errs = append(errs, errors.New(`missing required field "health.wifiStrength"`))
+		}
+		if p.Health.BatteryPercent == nil {
+			errs = append(errs, errors.New(`missing required field "health.batteryPercent"`))
+		} else if *p.Health.BatteryPercent < 0 || *p.Health.BatteryPercent > 100 {
+			errs = append(errs, errors.New(`field "health.batteryPercent" must be between 0 and 100`))
+		}
+		if p.Health.UptimeSeconds != nil && *p.Health.UptimeSeconds < 0 {
+			errs = append(errs, errors.New(`field "health.uptimeSeconds" must not be negative`))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *Measurement) validate(field string, min, max float64) []error {
+	if m == nil {
+		return nil
+	}
+
+	var errs []error
+	if m.Value == nil {
+		errs = append(errs, fmt.Errorf("missing required field %q", field+".value"))
+	} else if *m.Value < min || *m.Value > max {
+		errs = append(errs, fmt.Errorf("field %q must be between %g and %g, got %g", field+".value", min, max, *m.Value))
+	}
+
+	if m.Severity != nil && (*m.Severity < int(hm_payload_v1.NORMAL) || *m.Severity > int(hm_payload_v1.CRITICAL)) {
+		errs = append(errs, fmt.Errorf("field %q must be between %d and %d", field+".severity", hm_payload_v1.NORMAL, hm_payload_v1.CRITICAL))
+	}
+
+	return errs
+}
+
+// absent returns the metric when the measurement was not reported
+func (m *Measurement) absent(metric hm_payload_v1.Metric) hm_payload_v1.Metric {
+	if m == nil {
+		return metric
+	}
+	return 0
+}
+
+func (m *Measurement) value() float64 {
+	if m == nil || m.Value == nil {
+		return 0
+	}
+	return *m.Value
+}
+
+func (m *Measurement) severity() hm_payload_v1.SeverityLevel {
+	if m == nil || m.Severity == nil {
+		return hm_payload_v1.NORMAL
+	}
+	return hm_payload_v1.SeverityLevel(*m.Severity)
+}
+
+// DeviceTimestamp returns when the device took the reading, or nil when the
+// message carries no timestamp.
+func (p *Payload) DeviceTimestamp() *time.Time {
+	if p.Timestamp == nil {
+		return nil
+	}
+	timestamp := time.Unix(*p.Timestamp, 0).UTC()
+	return &timestamp
+}
+
+// Data converts the payload to the v1 data stored in sensor_data.
+func (p *Payload) Data() hm_payload_v1.Data {
+	data := hm_payload_v1.Data{Absent: hm_payload_v1.MetricAll}
+
+	if sensors := p.Sensors; sensors != nil {
+		data.Sensors = hm_payload_v1.SensorData{
+			Temperature:          float32(sensors.Temperature.value()),
+			TemperaturaSeverity:  sensors.Temperature.severity(),
+			Moisture:             float32(sensors.Moisture.value()),
+			MoistureSeverity:     sensors.Moisture.severity(),
+			Ph:                   float32(sensors.Ph.value()),
+			PhSeverity:           sensors.Ph.severity(),
+			Conductivity:         int(sensors.Conductivity.value()),
+			ConductivitySeverity: sensors.Conductivity.severity(),
+			Nitrogen:             int(sensors.Nitrogen.value()),
+			NitrogenSeverity:     sensors.Nitrogen.severity(),
+			Phosphorus:           int(sensors.Phosphorus.value()),
+			PhosphorusSeverity:   sensors.Phosphorus.severity(),
+			Potassium:            int(sensors.Potassium.value()),
+			PotassiumSeverity:    sensors.Potassium.severity(),
+		}
+		data.Absent = hm_payload_v1.MetricWaterLevel | hm_payload_v1.MetricRelay |
+			sensors.Temperature.absent(hm_payload_v1.MetricTemperature) |
+			sensors.Moisture.absent(hm_payload_v1.MetricMoisture) |
+			sensors.Ph.absent(hm_payload_v1.MetricPh) |
+			sensors.Conductivity.absent(hm_payload_v1.MetricConductivity) |
+			sensors.Nitrogen.absent(hm_payload_v1.MetricNitrogen) |
+			sensors.Phosphorus.absent(hm_payload_v1.MetricPhosphorus) |
+			sensors.Potassium.absent(hm_payload_v1.MetricPotassium)
+	}
+
+	if p.WaterLevelCm != nil {
+		data.Sensors.WaterLevelCm = *p.WaterLevelCm
+		data.Absent &^= hm_payload_v1.MetricWaterLevel
+	}
+
+	if p.Relay != nil {
+		data.Absent &^= hm_payload_v1.MetricRelay
+		data.Relay.IsOn = *p.Relay.IsOn
+		if p.Relay.NextToggleInSeconds != nil {
+			data.Relay.NextToggleInSeconds = *p.Relay.NextToggleInSeconds
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,128 @@
+package hydroponic_manager_payload_v3
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	hm_payload_v2 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v2"
+)
+
+// Payload v3 is the binary form of v2 for battery powered devices: the
+// version byte followed by a CBOR map described in schema.cddl. It is
+// converted to v2 so both share validation and storage.
+
+const Version = 3
+
+type Payload struct {
+	FuseId       *uint64  `cbor:"1,keyasint"`
+	ClientId     string   `cbor:"2,keyasint,omitempty"`
+	Timestamp    *int64   `cbor:"3,keyasint,omitempty"`
+	Health       *Health  `cbor:"4,keyasint,omitempty"`
+	Sensors      *Sensors `cbor:"5,keyasint,omitempty"`
+	Relay        *Relay   `cbor:"6,keyasint,omitempty"`
+	WaterLevelCm *float32 `cbor:"7,keyasint,omitempty"`
+}
+
+type Sensors struct {
+	Moisture     *Measurement `cbor:"1,keyasint,omitempty"`
+	Temperature  *Measurement `cbor:"2,keyasint,omitempty"`
+	Conductivity *Measurement `cbor:"3,keyasint,omitempty"`
+	Ph           *Measurement `cbor:"4,keyasint,omitempty"`
+	Nitrogen     *Measurement `cbor:"5,keyasint,omitempty"`
+	Phosphorus   *Measurement `cbor:"6,keyasint,omitempty"`
+	Potassium    *Measurement `cbor:"7,keyasint,omitempty"`
+}
+
+type Measurement struct {
+	_        struct{} `cbor:",toarray"`
+	Value    float64
+	Severity int
+}
+
+type Relay struct {
+	_                   struct{} `cbor:",toarray"`
+	IsOn                bool
+	NextToggleInSeconds int
+}
+
+type Health struct {
+	_               struct{} `cbor:",toarray"`
+	WifiStrength    int
+	BatteryPercent  int
+	FirmwareVersion string
+	UptimeSeconds   int64
+}
+
+// ParsePayload decodes a v3 message, including its leading version byte,
+// and returns it as a validated v2 payload.
+func ParsePayload(payload []byte) (*hm_payload_v2.Payload, error) {
+	if len(payload) < 2 || payload[0] != Version {
+		return nil, fmt.Errorf("invalid Hydroponic Manager v3 payload: missing version byte")
+	}
+
+	var message Payload
+	if err := cbor.Unmarshal(payload[1:], &message); err != nil {
+		return nil, fmt.Errorf("invalid Hydroponic Manager v3 CBOR: %w", err)
+	}
+
+	converted := message.toV2()
+	if err := converted.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Hydroponic Manager v3 payload: %w", err)
+	}
+
+	return converted, nil
+}
+
+func (p *Payload) toV2() *hm_payload_v2.Payload {
+	// Validation expects the v2 version, the v3 version is the selector byte
+	version := hm_payload_v2.Version
+	converted := &hm_payload_v2.Payload{
+		Version:      &version,
+		ClientId:     p.ClientId,
+		Timestamp:    p.Timestamp,
+		WaterLevelCm: p.WaterLevelCm,
+	}
+
+	if p.FuseId != nil {
+		fuseId := strconv.FormatUint(*p.FuseId, 10)
+		converted.FuseId = &fuseId
+	}
+
+	if sensors := p.Sensors; sensors != nil {
+		converted.Sensors = &hm_payload_v2.Sensors{
+			Moisture:     sensors.Moisture.toV2(),
+			Temperature:  sensors.Temperature.toV2(),
+			Conductivity: sensors.Conductivity.toV2(),
+			Ph:           sensors.Ph.toV2(),
+			Nitrogen:     sensors.Nitrogen.toV2(),
+			Phosphorus:   sensors.Phosphorus.toV2(),
+			Potassium:    sensors.Potassium.toV2(),
+		}
+	}
+
+	if relay := p.Relay; relay != nil {
+		converted.Relay = &hm_payload_v2.Relay{
+			IsOn:                &relay.IsOn,
+			NextToggleInSeconds: &relay.NextToggleInSeconds,
+		}
+	}
+
+	if health := p.Health; health != nil {
+		converted.Health = &hm_payload_v2.Health{
+			WifiStrength:    &health.WifiStrength,
+			BatteryPercent:  &health.BatteryPercent,
+			FirmwareVersion: health.FirmwareVersion,
+			UptimeSeconds:   &health.UptimeSeconds,
+		}
+	}
+
+	return converted
+}
+
+func (m *Measurement) toV2() *hm_payload_v2.Measurement {
+	if m == nil {
+		return nil
+	}
+	return &hm_payload_v2.Measurement{Value: &m.Value, Severity: &m.Severity}
+}
@@ -0,0 +1,43 @@
+package hydroponic_manager_worker
+
+import (
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	hm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v1"
+)
+
+func (hm *HydroponicManagerWorker) SeverityMetrics() []string {
+	return []string{"temperature", "moisture", "ph", "conductivity", "nitrogen", "phosphorus", "potassium", "water_level"}
+}
+
+func (hm *HydroponicManagerWorker) EvaluateSeverity(reading *workers.Reading, thresholds workers.SeverityThresholds) {
+	data, ok := reading.Data.(hm_payload_v1.Data)
+	if !ok {
+		return
+	}
+
+	// Absent metrics read as zero, evaluating them would raise false alerts
+	evaluate := func(metric string, present hm_payload_v1.Metric, value float64) hm_payload_v1.SeverityLevel {
+		if !data.Has(present) {
+			return hm_payload_v1.UNKNOWN
+		}
+		severity, exists := thresholds.Evaluate(metric, value)
+		if !exists {
+			return hm_payload_v1.UNKNOWN
+		}
+		return hm_payload_v1.SeverityLevel(severity)
+	}
+
+	sensors := data.Sensors
+	data.ServerSeverity = &hm_payload_v1.ServerSeverity{
+		Temperature:  evaluate("temperature", hm_payload_v1.MetricTemperature, float64(sensors.Temperature)),
+		Moisture:     evaluate("moisture", hm_payload_v1.MetricMoisture, float64(sensors.Moisture)),
+		Ph:           evaluate("ph", hm_payload_v1.MetricPh, float64(sensors.Ph)),
+		Conductivity: evaluate("conductivity", hm_payload_v1.MetricConductivity, float64(sensors.Conductivity)),
+		Nitrogen:     evaluate("nitrogen", hm_payload_v1.MetricNitrogen, float64(sensors.Nitrogen)),
+		Phosphorus:   evaluate("phosphorus", hm_payload_v1.MetricPhosphorus, float64(sensors.Phosphorus)),
+		Potassium:    evaluate("potassium", hm_payload_v1.MetricPotassium, float64(sensors.Potassium)),
+		WaterLevel:   evaluate("water_level", hm_payload_v1.MetricWaterLevel, float64(sensors.WaterLevelCm)),
+	}
+
+	reading.Data = data
+}
@@ -2,6 +2,8 @@ package hydroponic_manager_worker
 
 import (
 	"fmt"
+	"time"
+
 	hm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v1"
 )
 
@@ -28,6 +30,7 @@ type HydroponicManagerSensorData struct {
 	PhosphorusSeverity   SeverityLevel `json:"phosphorusSeverity"`
 	Potassium            int           `json:"potassium"`
 	PotassiumSeverity    SeverityLevel `json:"potassiumSeverity"`
+	WaterLevelCm         float32       `json:"waterLevelCm"`
 }
 
 type HydroponicManagerRelay struct {
@@ -35,27 +38,83 @@ type HydroponicManagerRelay struct {
 	NextToggleInSeconds int  `json:"nextToggleInSeconds"`
 }
 
+// HydroponicManagerServerSeverity holds the severities computed by the server
+// from the configured thresholds, -1 for metrics without a threshold.
+type HydroponicManagerServerSeverity struct {
+	TemperatureSeverity  SeverityLevel `json:"temperatureSeverity"`
+	MoistureSeverity     SeverityLevel `json:"moistureSeverity"`
+	PhSeverity           SeverityLevel `json:"phSeverity"`
+	ConductivitySeverity SeverityLevel `json:"conductivitySeverity"`
+	NitrogenSeverity     SeverityLevel `json:"nitrogenSeverity"`
+	PhosphorusSeverity   SeverityLevel `json:"phosphorusSeverity"`
+	PotassiumSeverity    SeverityLevel `json:"potassiumSeverity"`
+	WaterLevelSeverity   SeverityLevel `json:"waterLevelSeverity"`
+}
+
 type HydroponicManagerSensorDataResponse struct {
 	PayloadVersion int `json:"v"`
+	// Timestamp is when the row was received, or the start of its interval
+	Timestamp time.Time `json:"timestamp"`
 	HydroponicManagerSensorData
 	HydroponicManagerRelay
+	ServerSeverity *HydroponicManagerServerSeverity `json:"serverSeverity,omitempty"`
+	// Missing lists the metrics the device did not report, their values are zero
+	Missing []string `json:"missing,omitempty"`
+	absent  hm_payload_v1.Metric
 }
 
-func CalculateSeverityLevel(value int) SeverityLevel {
-	switch {
-	case value >= 80:
-		return CRITICAL
-	case value >= 50:
-		return WARNING
-	default:
-		return NORMAL
+var metricNames = []struct {
+	metric hm_payload_v1.Metric
+	name   string
+}{
+	{hm_payload_v1.MetricTemperature, "temperature"},
+	{hm_payload_v1.MetricMoisture, "moisture"},
+	{hm_payload_v1.MetricPh, "ph"},
+	{hm_payload_v1.MetricConductivity, "conductivity"},
+	{hm_payload_v1.MetricNitrogen, "nitrogen"},
+	{hm_payload_v1.MetricPhosphorus, "phosphorus"},
+	{hm_payload_v1.MetricPotassium, "potassium"},
+	{hm_payload_v1.MetricWaterLevel, "waterLevelCm"},
+	{hm_payload_v1.MetricRelay, "isOn"},
+}
+
+func (r *HydroponicManagerSensorDataResponse) setAbsent(absent hm_payload_v1.Metric) {
+	r.absent = absent
+	r.Missing = nil
+	for _, metric := range metricNames {
+		if absent&metric.metric != 0 {
+			r.Missing = append(r.Missing, metric.name)
+		}
+	}
+}
+
+// WorstSeverity merges the server severities of a bucket, keeping the worst
+// level of each metric.
+func WorstSeverity(current *HydroponicManagerServerSeverity, next *HydroponicManagerServerSeverity) *HydroponicManagerServerSeverity {
+	if next == nil {
+		return current
+	}
+	if current == nil {
+		worst := *next
+		return &worst
+	}
+
+	return &HydroponicManagerServerSeverity{
+		TemperatureSeverity:  max(current.TemperatureSeverity, next.TemperatureSeverity),
+		MoistureSeverity:     max(current.MoistureSeverity, next.MoistureSeverity),
+		PhSeverity:           max(current.PhSeverity, next.PhSeverity),
+		ConductivitySeverity: max(current.ConductivitySeverity, next.ConductivitySeverity),
+		NitrogenSeverity:     max(current.NitrogenSeverity, next.NitrogenSeverity),
+		PhosphorusSeverity:   max(current.PhosphorusSeverity, next.PhosphorusSeverity),
+		PotassiumSeverity:    max(current.PotassiumSeverity, next.PotassiumSeverity),
+		WaterLevelSeverity:   max(current.WaterLevelSeverity, next.WaterLevelSeverity),
 	}
 }
 
 func ConvertCompressedPayloadToSensorDataResponse(payloadVersion int, payload string) *HydroponicManagerSensorDataResponse {
 
 	switch payloadVersion {
-	case HydroponicManagerMessageV1:
+	case HydroponicManagerStorageV1, HydroponicManagerStorageV2, HydroponicManagerStorageV3:
 		data, err := hm_payload_v1.DecompressDataFromDatabase(payload)
 		if err != nil {
 			fmt.Printf("Failed to decompress Hydroponic Manager v1 data: %v\n", err)
@@ -65,8 +124,22 @@ func ConvertCompressedPayloadToSensorDataResponse(payloadVersion int, payload st
 		sensor := data.Sensors
 		relay := data.Relay
 
-		return &HydroponicManagerSensorDataResponse{
-			PayloadVersion: HydroponicManagerMessageV1,
+		var serverSeverity *HydroponicManagerServerSeverity
+		if severity := data.ServerSeverity; severity != nil {
+			serverSeverity = &HydroponicManagerServerSeverity{
+				TemperatureSeverity:  SeverityLevel(severity.Temperature),
+				MoistureSeverity:     SeverityLevel(severity.Moisture),
+				PhSeverity:           SeverityLevel(severity.Ph),
+				ConductivitySeverity: SeverityLevel(severity.Conductivity),
+				NitrogenSeverity:     SeverityLevel(severity.Nitrogen),
+				PhosphorusSeverity:   SeverityLevel(severity.Phosphorus),
+				PotassiumSeverity:    SeverityLevel(severity.Potassium),
+				WaterLevelSeverity:   SeverityLevel(severity.WaterLevel),
+			}
+		}
+
+		response := &HydroponicManagerSensorDataResponse{
+			PayloadVersion: payloadVersion,
 			HydroponicManagerSensorData: HydroponicManagerSensorData{
 				Temperature:          sensor.Temperature,
 				TemperaturaSeverity:  SeverityLevel(sensor.TemperaturaSeverity),
@@ -82,18 +155,22 @@ func ConvertCompressedPayloadToSensorDataResponse(payloadVersion int, payload st
 				PhosphorusSeverity:   SeverityLevel(sensor.PhosphorusSeverity),
 				Potassium:            sensor.Potassium,
 				PotassiumSeverity:    SeverityLevel(sensor.PotassiumSeverity),
+				WaterLevelCm:         sensor.WaterLevelCm,
 			},
 			HydroponicManagerRelay: HydroponicManagerRelay{
 				IsOn:                relay.IsOn,
 				NextToggleInSeconds: relay.NextToggleInSeconds,
 			},
+			ServerSeverity: serverSeverity,
 		}
+		response.setAbsent(data.Absent)
+		return response
 	default:
 		fmt.Printf("Unsupported Hydroponic Manager message version: %d\n", payloadVersion)
 		return nil
 	}
 
 	return &HydroponicManagerSensorDataResponse{
-		PayloadVersion: HydroponicManagerMessageV1,
+		PayloadVersion: payloadVersion,
 	}
 }
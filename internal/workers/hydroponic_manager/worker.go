@@ -1,18 +1,20 @@
 package hydroponic_manager_worker
 
 import (
-	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/services"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
 	hm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v1"
+	hm_payload_v2 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v2"
+	hm_payload_v3 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/hydroponic_manager/payloads/hydroponic_manager_payload_v3"
 )
 
 const HydroponicManagerTopicID = 0
+const HydroponicManagerCommandTopic = "hydroponic-manager/commands"
 const DeviceName = "Hydroponic Manager"
 const DeviceType = "hydroponic-manager"
 const DeviceDescription = "Hydroponic Manager Device"
@@ -20,78 +22,140 @@ const DeviceDescription = "Hydroponic Manager Device"
 // List of suported Paylaods versions
 const (
 	HydroponicManagerMessageV1 = 1
+	// V2 is JSON, detected by the leading '{'
+	HydroponicManagerMessageV2 = 2
+	// V3 is CBOR, detected by the leading version byte 0x03
+	HydroponicManagerMessageV3 = 3
+)
+
+// Versions of the compressed payload stored in sensor_data
+const (
+	HydroponicManagerStorageV1 = 1
+	// V2 adds the water level
+	HydroponicManagerStorageV2 = 2
+	// V3 adds the server computed severities
+	HydroponicManagerStorageV3 = 3
 )
 
 type HydroponicManagerWorker struct {
-	db     *database.Database
 	client *services.MQTTClient
 }
 
-func NewHydroponicManagerListener(db *database.Database, client *services.MQTTClient) *HydroponicManagerWorker {
-	hm := &HydroponicManagerWorker{
-		db:     db,
+func NewHydroponicManagerWorker(client *services.MQTTClient) *HydroponicManagerWorker {
+	return &HydroponicManagerWorker{
 		client: client,
 	}
+}
+
+func (hm *HydroponicManagerWorker) Info() workers.DeviceInfo {
+	return workers.DeviceInfo{
+		Type:        DeviceType,
+		Name:        DeviceName,
+		Description: DeviceDescription,
+		TopicID:     HydroponicManagerTopicID,
+	}
+}
 
-	client.Subscribe("hydroponic-manager/sensors", hm.Handler)
-	return hm
+func (hm *HydroponicManagerWorker) Topics() []string {
+	return []string{"hydroponic-manager/sensors", "hydroponic-manager/+/sensors"}
 }
 
-func (hm *HydroponicManagerWorker) Handler(msg mqtt.Message) {
-	fmt.Printf("Received message on topic %s: %s\n", msg.Topic(), string(msg.Payload()))
+func (hm *HydroponicManagerWorker) Parse(payload []byte) (*workers.Reading, error) {
+	if len(payload) > 0 && payload[0] == '{' {
+		message, err := hm_payload_v2.ParsePayload(payload)
+		if err != nil {
+			return nil, err
+		}
+		return readingFromV2(message, HydroponicManagerMessageV2), nil
+	}
+
+	if len(payload) > 0 && payload[0] == HydroponicManagerMessageV3 {
+		message, err := hm_payload_v3.ParsePayload(payload)
+		if err != nil {
+			return nil, err
+		}
+		return readingFromV2(message, HydroponicManagerMessageV3), nil
+	}
 
-	var payload string = string(msg.Payload())
-	var parts = strings.Split(payload, ";")
+	var parts = strings.Split(string(payload), ";")
 
-	if len(parts) < 1 {
-		fmt.Printf("Invalid Hydroponic Manager message format: %s\n", payload)
-		return
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid Hydroponic Manager message format: %s", payload)
 	}
 
 	version, err := strconv.Atoi(parts[0])
 	if err != nil {
-		fmt.Printf("Invalid Hydroponic Manager message version: %s\n", parts[0])
-		return
+		return nil, fmt.Errorf("invalid Hydroponic Manager message version: %s", parts[0])
 	}
 
-	var compressedData string
-	var fuseID string
-	var payloadVersion int = version
-
 	switch version {
 	case HydroponicManagerMessageV1:
 		message, err := hm_payload_v1.ParsePayload(parts)
 		if err != nil {
-			fmt.Printf("Failed to parse Hydroponic Manager message: %v\n", err)
-			return
+			return nil, err
 		}
-		fuseID = message.FuseId
-		compressedData, err = hm_payload_v1.CompressDataToDatabase(message.Data)
+		health, err := workers.ParseHealthSection(parts[3:])
 		if err != nil {
-			fmt.Printf("Failed to compress Hydroponic Manager v1 data: %v\n", err)
-			return
+			return nil, err
 		}
-		fmt.Printf("Parsed Hydroponic Manager v1 Payload: %+v\n", message)
+		return &workers.Reading{
+			FuseID:         message.FuseId,
+			ClientID:       message.ClientId,
+			PayloadVersion: version,
+			Data:           message.Data,
+			Health:         health,
+		}, nil
 	default:
-		fmt.Printf("Unsupported water Hydroponic Manager meter message version: %d\n", version)
-		return
+		return nil, fmt.Errorf("unsupported Hydroponic Manager message version: %d", version)
 	}
+}
 
-	sr := hm.db.SensorRepository()
-	dr := hm.db.DeviceRepository()
+func (hm *HydroponicManagerWorker) Encode(reading *workers.Reading) (int, string, error) {
+	data, ok := reading.Data.(hm_payload_v1.Data)
+	if !ok {
+		return 0, "", fmt.Errorf("unexpected Hydroponic Manager data type %T", reading.Data)
+	}
 
-	// TODO:Parse payload to get location and wifi/battery status
-	device, err := dr.CreateAndGetDeviceIfDoesNotExist(fuseID, DeviceName, DeviceDescription, "Unknown", DeviceType, 0, 0)
+	compressedData, err := hm_payload_v1.CompressDataToDatabase(data)
 	if err != nil {
-		fmt.Printf("Failed to insert device: %v\n", err)
-		return
+		return 0, "", err
 	}
 
-	err = sr.InsertSensorData(context.Background(), device.ID, HydroponicManagerTopicID, compressedData, payloadVersion)
+	if data.ServerSeverity != nil {
+		return HydroponicManagerStorageV3, compressedData, nil
+	}
+	return HydroponicManagerStorageV2, compressedData, nil
+}
 
-	if err != nil {
-		fmt.Printf("Failed to insert sensor data for device %s: %v\n", fuseID, err)
-		return
+func (hm *HydroponicManagerWorker) Decode(payloadVersion int, payload string) (any, error) {
+	response := ConvertCompressedPayloadToSensorDataResponse(payloadVersion, payload)
+	if response == nil {
+		return nil, fmt.Errorf("failed to decode Hydroponic Manager payload version %d", payloadVersion)
+	}
+	return response, nil
+}
+
+// readingFromV2 converts a validated v2 payload, also used for the binary v3
+// payloads.
+func readingFromV2(message *hm_payload_v2.Payload, version int) *workers.Reading {
+	reading := &workers.Reading{
+		FuseID:          *message.FuseId,
+		ClientID:        message.ClientId,
+		PayloadVersion:  version,
+		Data:            message.Data(),
+		DeviceTimestamp: message.DeviceTimestamp(),
+	}
+
+	if health := message.Health; health != nil {
+		reading.Health = &database.DeviceHealth{
+			WifiStrength:    *health.WifiStrength,
+			BatteryPercent:  *health.BatteryPercent,
+			FirmwareVersion: health.FirmwareVersion,
+		}
+		if health.UptimeSeconds != nil {
+			reading.Health.UptimeSeconds = *health.UptimeSeconds
+		}
 	}
 
+	return reading
 }
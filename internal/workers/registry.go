@@ -0,0 +1,270 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/services"
+)
+
+type Registry struct {
+	db      *database.Database
+	client  *services.MQTTClient
+	workers []DeviceWorker
+	byType  map[string]DeviceWorker
+
+	// synced holds the devices whose thresholds were pushed since the last
+	// broker connection
+	synced   map[string]bool
+	syncedMu sync.Mutex
+
+	archiveRawMessages bool
+	// commandAckTimeout is how long the MQTT 5 reply to a command is awaited
+	commandAckTimeout time.Duration
+
+	readingListeners   []ReadingListener
+	readingListenersMu sync.Mutex
+}
+
+// ReadingListener is called after a live reading is stored, with the device
+// created on its first message.
+type ReadingListener func(device *database.Device, worker DeviceWorker, reading *Reading)
+
+func NewRegistry(db *database.Database, client *services.MQTTClient) *Registry {
+	registry := &Registry{
+		db:     db,
+		client: client,
+		byType: make(map[string]DeviceWorker),
+		synced: make(map[string]bool),
+
+		commandAckTimeout: DefaultCommandAckTimeout,
+	}
+
+	client.OnConnect(registry.resetThresholdSync)
+	return registry
+}
+
+// Register adds a device worker and subscribes to its topics. Workers should
+// be registered before the MQTT client starts so messages queued in the
+// session are not missed.
+func (r *Registry) Register(worker DeviceWorker) error {
+	info := worker.Info()
+	if _, exists := r.byType[info.Type]; exists {
+		return fmt.Errorf("device worker for type %s is already registered", info.Type)
+	}
+	for _, registered := range r.workers {
+		if registered.Info().TopicID == info.TopicID {
+			return fmt.Errorf("device worker %s uses topic ID %d already used by %s", info.Type, info.TopicID, registered.Info().Type)
+		}
+	}
+
+	r.workers = append(r.workers, worker)
+	r.byType[info.Type] = worker
+
+	for _, topic := range worker.Topics() {
+		if err := r.client.Subscribe(topic, r.handler(worker)); err != nil {
+			return fmt.Errorf("failed to subscribe %s worker to %s: %w", info.Type, topic, err)
+		}
+	}
+
+	if commandWorker, ok := worker.(CommandWorker); ok {
+		for _, topic := range commandWorker.AckTopics() {
+			if err := r.client.Subscribe(topic, r.ackHandler(commandWorker)); err != nil {
+				return fmt.Errorf("failed to subscribe %s worker to %s: %w", info.Type, topic, err)
+			}
+		}
+	}
+
+	fmt.Printf("Registered device worker %s\n", info.Type)
+	return nil
+}
+
+// EnableRawArchive stores every received message in raw_messages before it is
+// parsed, so it can be replayed after a decoder fix.
+func (r *Registry) EnableRawArchive() {
+	r.archiveRawMessages = true
+}
+
+// SetCommandAckTimeout sets how long the MQTT 5 reply to a command is awaited,
+// matching the ack timeout of the CommandTracker.
+func (r *Registry) SetCommandAckTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		r.commandAckTimeout = timeout
+	}
+}
+
+// OnReading registers a listener called after every live reading is stored.
+func (r *Registry) OnReading(listener ReadingListener) {
+	r.readingListenersMu.Lock()
+	defer r.readingListenersMu.Unlock()
+	r.readingListeners = append(r.readingListeners, listener)
+}
+
+func (r *Registry) Workers() []DeviceWorker {
+	return r.workers
+}
+
+func (r *Registry) ByDeviceType(deviceType string) (DeviceWorker, bool) {
+	worker, exists := r.byType[deviceType]
+	return worker, exists
+}
+
+// ByTopic returns the worker subscribed to a topic filter matching the topic.
+func (r *Registry) ByTopic(topic string) (DeviceWorker, bool) {
+	for _, worker := range r.workers {
+		for _, filter := range worker.Topics() {
+			if services.TopicMatchesFilter(filter, topic) {
+				return worker, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) handler(worker DeviceWorker) services.MqttMessageHandler {
+	return func(msg mqtt.Message) {
+		fmt.Printf("Received message on topic %s: %s\n", msg.Topic(), string(msg.Payload()))
+
+		// The archived message and its reading share the receive time, so a
+		// replay can tell which reading came from which message
+		receivedAt := time.Now()
+		if r.archiveRawMessages {
+			err := r.db.RawMessageRepository().InsertRawMessage(context.Background(), msg.Topic(), msg.Payload(), msg.Qos(), receivedAt)
+			if err != nil {
+				fmt.Printf("Failed to archive message on topic %s: %v\n", msg.Topic(), err)
+			}
+		}
+
+		if _, err := r.ingest(context.Background(), worker, msg.Payload(), receivedAt, ingestLive); err != nil {
+			fmt.Printf("Failed to ingest %s message: %v\n", worker.Info().Type, err)
+			r.deadLetter(msg.Topic(), worker, msg.Payload(), err)
+		}
+	}
+}
+
+// Ingest parses a payload, creates the device on its first message and stores
+// the compressed reading.
+func (r *Registry) Ingest(ctx context.Context, worker DeviceWorker, payload []byte) error {
+	_, err := r.ingest(ctx, worker, payload, time.Now(), ingestLive)
+	return err
+}
+
+// IngestAt is Ingest for a message received at receivedAt, used to reprocess
+// stored messages without moving them in the time series. Stored messages do
+// not trigger a threshold sync nor update the current device health. A
+// reading already stored for the message is replaced when replace is set and
+// kept otherwise, reported by the returned bool.
+func (r *Registry) IngestAt(ctx context.Context, worker DeviceWorker, payload []byte, receivedAt time.Time, replace bool) (bool, error) {
+	mode := ingestStored
+	if replace {
+		mode = ingestReplace
+	}
+	return r.ingest(ctx, worker, payload, receivedAt, mode)
+}
+
+type ingestMode int
+
+const (
+	ingestLive ingestMode = iota
+	// ingestStored keeps the reading already stored for the message
+	ingestStored
+	// ingestReplace replaces the reading already stored for the message
+	ingestReplace
+)
+
+func (r *Registry) ingest(ctx context.Context, worker DeviceWorker, payload []byte, receivedAt time.Time, mode ingestMode) (bool, error) {
+	live := mode == ingestLive
+	info := worker.Info()
+
+	reading, err := worker.Parse(payload)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse message: %w", err)
+	}
+	fmt.Printf("Parsed %s v%d payload: %+v\n", info.Type, reading.PayloadVersion, reading)
+
+	sr := r.db.SensorRepository()
+	dr := r.db.DeviceRepository()
+
+	health := database.DeviceHealth{}
+	if reading.Health != nil {
+		health = *reading.Health
+	}
+
+	// TODO:Parse payload to get location
+	device, err := dr.CreateAndGetDeviceIfDoesNotExist(reading.FuseID, info.Name, info.Description, "Unknown", info.Type, health.WifiStrength, health.BatteryPercent)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert device: %w", err)
+	}
+
+	if reading.Health != nil && live {
+		err = dr.UpdateDeviceHealth(ctx, device.ID, *reading.Health)
+		if err != nil {
+			return false, fmt.Errorf("failed to update health for device %s: %w", reading.FuseID, err)
+		}
+	}
+
+	if severityWorker, ok := worker.(SeverityWorker); ok {
+		thresholds, err := r.db.ThresholdRepository().GetEffectiveThresholds(ctx, device.ID)
+		if err != nil {
+			return false, fmt.Errorf("failed to get thresholds for device %s: %w", reading.FuseID, err)
+		}
+		if len(thresholds) > 0 {
+			severityWorker.EvaluateSeverity(reading, NewSeverityThresholds(thresholds))
+		}
+	}
+
+	storageVersion, compressedData, err := worker.Encode(reading)
+	if err != nil {
+		return false, fmt.Errorf("failed to compress data: %w", err)
+	}
+
+	if live {
+		err = sr.InsertSensorData(ctx, device.ID, info.TopicID, compressedData, storageVersion, reading.DeviceTimestamp, receivedAt)
+		if err != nil {
+			return false, fmt.Errorf("failed to insert sensor data for device %s: %w", reading.FuseID, err)
+		}
+	}
+
+	// A stored message already ingested has its reading and health recorded,
+	// otherwise its health only adds to the history at the time it arrived
+	// since it is older than the current device health
+	var existed bool
+	if !live {
+		existed, err = sr.InsertStoredSensorData(ctx, device.ID, info.TopicID, compressedData, storageVersion, reading.DeviceTimestamp, receivedAt, mode == ingestReplace)
+		if err != nil {
+			return false, fmt.Errorf("failed to insert sensor data for device %s: %w", reading.FuseID, err)
+		}
+		if !existed && reading.Health != nil {
+			err = dr.InsertDeviceHealthHistory(ctx, device.ID, *reading.Health, receivedAt)
+			if err != nil {
+				return false, fmt.Errorf("failed to record health for device %s: %w", reading.FuseID, err)
+			}
+		}
+	}
+
+	if live {
+		r.readingListenersMu.Lock()
+		listeners := append([]ReadingListener{}, r.readingListeners...)
+		r.readingListenersMu.Unlock()
+
+		for _, listener := range listeners {
+			listener(device, worker, reading)
+		}
+	}
+
+	// Pushed in the background so publishing the commands does not hold the
+	// message pipeline
+	if live && r.needsThresholdSync(device, reading.Health) {
+		go func() {
+			if err := r.SyncThresholds(context.Background(), device); err != nil {
+				fmt.Printf("Failed to sync thresholds: %v\n", err)
+			}
+		}()
+	}
+
+	return existed, nil
+}
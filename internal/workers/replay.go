@@ -0,0 +1,106 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const replayBatchSize = 500
+
+type ReplayOptions struct {
+	Start time.Time
+	End   time.Time
+	// FuseID limits the replay to one device when set
+	FuseID string
+	// DryRun only parses and encodes the messages without writing them
+	DryRun bool
+	// Replace overwrites the reading stored for each replayed message, matched
+	// by device and receive time. Readings without an archived message, like
+	// those received while the archive was disabled, are never touched.
+	// Without it, messages whose reading is stored are skipped.
+	Replace bool
+}
+
+type ReplayStats struct {
+	Messages int
+	Replayed int
+	Skipped  int
+	Failed   int
+	// Existing counts the messages whose reading was already stored, replaced
+	// with Replace and kept otherwise
+	Existing int
+}
+
+// Replay feeds archived raw messages back through the registered workers in
+// the order they were received, keeping their original receive time. Each
+// message is written in a single statement, so an interrupted replay leaves
+// every reading either replayed or untouched.
+func (r *Registry) Replay(ctx context.Context, options ReplayOptions) (ReplayStats, error) {
+	stats := ReplayStats{}
+
+	if options.Replace && options.FuseID == "" {
+		return stats, fmt.Errorf("replacing readings requires a device")
+	}
+
+	var afterID int64
+	for {
+		messages, err := r.db.RawMessageRepository().GetRawMessages(ctx, options.Start, options.End, afterID, replayBatchSize)
+		if err != nil {
+			return stats, err
+		}
+
+		for _, message := range messages {
+			afterID = message.ID
+			stats.Messages++
+
+			worker, exists := r.ByTopic(message.Topic)
+			if !exists {
+				stats.Skipped++
+				continue
+			}
+
+			reading, err := worker.Parse(message.Payload)
+			if err != nil {
+				stats.Failed++
+				fmt.Printf("Replay of message %d failed to parse: %v\n", message.ID, err)
+				continue
+			}
+
+			if options.FuseID != "" && reading.FuseID != options.FuseID {
+				stats.Skipped++
+				continue
+			}
+
+			if options.DryRun {
+				storageVersion, compressedData, err := worker.Encode(reading)
+				if err != nil {
+					stats.Failed++
+					fmt.Printf("Replay of message %d failed to encode: %v\n", message.ID, err)
+					continue
+				}
+				fmt.Printf("Replay of message %d from %s at %s: v%d %s\n", message.ID, reading.FuseID, message.ReceivedAt.Format(time.RFC3339), storageVersion, compressedData)
+				stats.Replayed++
+				continue
+			}
+
+			existed, err := r.IngestAt(ctx, worker, message.Payload, message.ReceivedAt, options.Replace)
+			if err != nil {
+				stats.Failed++
+				fmt.Printf("Replay of message %d failed: %v\n", message.ID, err)
+				continue
+			}
+			if existed {
+				stats.Existing++
+				if !options.Replace {
+					continue
+				}
+			}
+			stats.Replayed++
+		}
+
+		if len(messages) < replayBatchSize {
+			return stats, nil
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+// Severity levels computed by the server, matching the device enums.
+const (
+	SeverityNormal   = 0
+	SeverityWarning  = 1
+	SeverityCritical = 2
+)
+
+// PercentageThresholdMetric holds the warn and critical percentages (as min
+// and max) used to grade values against the other thresholds.
+const PercentageThresholdMetric = "percentage"
+
+const (
+	DefaultWarnPercent     = 0
+	DefaultCriticalPercent = 20
+)
+
+// SeverityWorker is implemented by device workers whose readings are graded
+// against the configured thresholds.
+type SeverityWorker interface {
+	// EvaluateSeverity stores the server severities in the reading data
+	EvaluateSeverity(reading *Reading, thresholds SeverityThresholds)
+	// SeverityMetrics lists the threshold metrics EvaluateSeverity reads
+	SeverityMetrics() []string
+}
+
+// ErrUnknownSeverityMetric is returned for thresholds no worker evaluates.
+var ErrUnknownSeverityMetric = errors.New("unknown severity metric")
+
+// ValidateSeverityMetric checks that a registered worker grades readings
+// against the metric, so a threshold stored for it is not silently ignored.
+func (r *Registry) ValidateSeverityMetric(metric string) error {
+	if metric == PercentageThresholdMetric {
+		return nil
+	}
+
+	var known []string
+	for _, worker := range r.workers {
+		severityWorker, ok := worker.(SeverityWorker)
+		if !ok {
+			continue
+		}
+		if slices.Contains(severityWorker.SeverityMetrics(), metric) {
+			return nil
+		}
+		known = append(known, severityWorker.SeverityMetrics()...)
+	}
+
+	return fmt.Errorf("%w %q, use one of %s or %s", ErrUnknownSeverityMetric, metric, strings.Join(known, ", "), PercentageThresholdMetric)
+}
+
+type SeverityThresholds map[string]database.Threshold
+
+func NewSeverityThresholds(thresholds []database.Threshold) SeverityThresholds {
+	byMetric := make(SeverityThresholds, len(thresholds))
+	for _, threshold := range thresholds {
+		byMetric[threshold.Metric] = threshold
+	}
+	return byMetric
+}
+
+// Evaluate grades a value against the [min, max] range of its metric, and
+// reports false when the metric has no threshold. Values inside the range are
+// NORMAL, except within warn percent of the range width from a bound, which
+// are WARNING. Outside the range, values are WARNING up to critical percent of
+// the range width away from the nearest bound and CRITICAL beyond it.
+func (t SeverityThresholds) Evaluate(metric string, value float64) (int, bool) {
+	threshold, exists := t[metric]
+	if !exists {
+		return SeverityNormal, false
+	}
+
+	warnPercent, criticalPercent := float64(DefaultWarnPercent), float64(DefaultCriticalPercent)
+	if percentage, exists := t[PercentageThresholdMetric]; exists {
+		warnPercent, criticalPercent = percentage.Min, percentage.Max
+	}
+
+	width := threshold.Max - threshold.Min
+	if width <= 0 {
+		return SeverityNormal, false
+	}
+
+	var distance float64
+	switch {
+	case value < threshold.Min:
+		distance = threshold.Min - value
+	case value > threshold.Max:
+		distance = value - threshold.Max
+	default:
+		margin := min(value-threshold.Min, threshold.Max-value)
+		if margin/width*100 < warnPercent {
+			return SeverityWarning, true
+		}
+		return SeverityNormal, true
+	}
+
+	if distance/width*100 >= criticalPercent {
+		return SeverityCritical, true
+	}
+	return SeverityWarning, true
+}
@@ -0,0 +1,95 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+// ErrInvalidTankProfile is wrapped by ValidateTankProfile.
+var ErrInvalidTankProfile = errors.New("invalid tank profile")
+
+// TankWorker is implemented by device workers measuring a tank level, whose
+// time series also carries the metrics derived from the device tank profile.
+type TankWorker interface {
+	AggregateTank(rows []database.SensorData, intervalMs int, start, end time.Time, profile *database.TankProfile) (any, error)
+}
+
+// TankMetrics are derived from a level reading and the tank profile.
+type TankMetrics struct {
+	// LevelCm is the liquid height above the tank bottom
+	LevelCm      float64 `json:"level_cm"`
+	VolumeLitres float64 `json:"volume_litres"`
+	// FillPercent is the level between the profile min and max levels
+	FillPercent float64 `json:"fill_percent"`
+	// RateLitresPerHour is positive while filling and negative while
+	// draining, nil for the first point of the series
+	RateLitresPerHour *float64 `json:"rate_litres_per_hour"`
+	// SecondsUntilEmpty estimates when the min level is reached at the
+	// current rate, nil unless the tank is draining
+	SecondsUntilEmpty *float64 `json:"seconds_until_empty"`
+}
+
+func ValidateTankProfile(profile database.TankProfile) error {
+	switch profile.Shape {
+	case database.TankShapeCylinder:
+		if profile.DiameterCm <= 0 {
+			return fmt.Errorf("%w: a cylinder requires a positive diameter_cm", ErrInvalidTankProfile)
+		}
+	case database.TankShapeBox:
+		if profile.LengthCm <= 0 || profile.WidthCm <= 0 {
+			return fmt.Errorf("%w: a box requires a positive length_cm and width_cm", ErrInvalidTankProfile)
+		}
+	default:
+		return fmt.Errorf("%w: shape must be %s or %s", ErrInvalidTankProfile, database.TankShapeCylinder, database.TankShapeBox)
+	}
+
+	if profile.HeightCm <= 0 {
+		return fmt.Errorf("%w: height_cm must be positive", ErrInvalidTankProfile)
+	}
+	if profile.MinLevelCm < 0 || profile.MinLevelCm >= profile.MaxLevelCm || profile.MaxLevelCm > profile.HeightCm {
+		return fmt.Errorf("%w: levels must satisfy 0 <= min_level_cm < max_level_cm <= height_cm", ErrInvalidTankProfile)
+	}
+
+	return nil
+}
+
+// TankCrossSectionCm2 returns the horizontal area of the tank.
+func TankCrossSectionCm2(profile *database.TankProfile) float64 {
+	if profile.Shape == database.TankShapeCylinder {
+		radius := profile.DiameterCm / 2
+		return math.Pi * radius * radius
+	}
+	return profile.LengthCm * profile.WidthCm
+}
+
+// NewTankMetrics derives the metrics of a reported level. previous holds the
+// metrics of the prior point of the series, elapsed the time since it.
+func NewTankMetrics(profile *database.TankProfile, reportedLevelCm float64, previous *TankMetrics, elapsed time.Duration) *TankMetrics {
+	area := TankCrossSectionCm2(profile)
+	level := min(max(reportedLevelCm+profile.SensorOffsetCm, 0), profile.HeightCm)
+
+	metrics := &TankMetrics{
+		LevelCm:      level,
+		VolumeLitres: area * level / 1000,
+		FillPercent:  min(max((level-profile.MinLevelCm)/(profile.MaxLevelCm-profile.MinLevelCm)*100, 0), 100),
+	}
+
+	if previous == nil || elapsed <= 0 {
+		return metrics
+	}
+
+	rate := (metrics.VolumeLitres - previous.VolumeLitres) / elapsed.Hours()
+	metrics.RateLitresPerHour = &rate
+
+	if rate < 0 {
+		usableLitres := max(area*(level-profile.MinLevelCm)/1000, 0)
+		seconds := usableLitres / -rate * 3600
+		metrics.SecondsUntilEmpty = &seconds
+	}
+
+	return metrics
+}
@@ -0,0 +1,117 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+)
+
+// ThresholdSyncSender is recorded as the sender of the commands pushing the
+// stored thresholds after a device reconnects.
+const ThresholdSyncSender = "threshold-sync"
+
+func (r *Registry) thresholdWorker(device *database.Device) (ThresholdWorker, error) {
+	worker, exists := r.ByDeviceType(device.Type)
+	if !exists {
+		return nil, fmt.Errorf("no device worker registered for type %s", device.Type)
+	}
+
+	thresholdWorker, ok := worker.(ThresholdWorker)
+	if !ok {
+		return nil, ErrCommandsNotSupported
+	}
+
+	return thresholdWorker, nil
+}
+
+// ValidateThreshold checks that the threshold builds a valid command for the
+// device without sending it.
+func (r *Registry) ValidateThreshold(device *database.Device, metric string, min, max float64) error {
+	worker, err := r.thresholdWorker(device)
+	if err != nil {
+		return err
+	}
+
+	command, args, err := worker.ThresholdCommand(metric, min, max)
+	if err != nil {
+		return err
+	}
+
+	_, err = worker.BuildCommand(device.FuseID, 0, command, args)
+	return err
+}
+
+// PushThreshold sends the command applying a stored threshold and records it
+// as the threshold's last sync.
+func (r *Registry) PushThreshold(ctx context.Context, device *database.Device, threshold *database.DeviceThreshold, sentBy string) (*database.DeviceCommand, error) {
+	worker, err := r.thresholdWorker(device)
+	if err != nil {
+		return nil, err
+	}
+
+	command, args, err := worker.ThresholdCommand(threshold.Metric, threshold.Min, threshold.Max)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCommand, err := r.SendCommand(ctx, device, command, args, sentBy)
+	if err != nil {
+		return deviceCommand, err
+	}
+
+	err = r.db.ThresholdRepository().MarkThresholdSynced(ctx, threshold.ID, deviceCommand.ID)
+	if err != nil {
+		return deviceCommand, err
+	}
+
+	return deviceCommand, nil
+}
+
+// SyncThresholds pushes every stored threshold of the device.
+func (r *Registry) SyncThresholds(ctx context.Context, device *database.Device) error {
+	thresholds, err := r.db.ThresholdRepository().GetThresholdsByDeviceID(ctx, device.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, threshold := range thresholds {
+		if _, err := r.PushThreshold(ctx, device, &threshold, ThresholdSyncSender); err != nil {
+			return fmt.Errorf("failed to push %s threshold to device %s: %w", threshold.Metric, device.FuseID, err)
+		}
+	}
+
+	if len(thresholds) > 0 {
+		fmt.Printf("Pushed %d thresholds to device %s\n", len(thresholds), device.FuseID)
+	}
+
+	return nil
+}
+
+// needsThresholdSync reports whether the message is the first one seen from
+// the device since we (re)connected to the broker, or the device rebooted
+// since its previous message, going by its uptime.
+func (r *Registry) needsThresholdSync(device *database.Device, health *database.DeviceHealth) bool {
+	if _, ok := r.byType[device.Type].(ThresholdWorker); !ok {
+		return false
+	}
+
+	r.syncedMu.Lock()
+	defer r.syncedMu.Unlock()
+
+	rebooted := health != nil && device.UptimeSeconds > 0 && health.UptimeSeconds < device.UptimeSeconds
+	if r.synced[device.FuseID] && !rebooted {
+		return false
+	}
+
+	r.synced[device.FuseID] = true
+	return true
+}
+
+// resetThresholdSync makes the next message of every device push its
+// thresholds again, since devices may have rebooted while we were offline.
+func (r *Registry) resetThresholdSync() {
+	r.syncedMu.Lock()
+	defer r.syncedMu.Unlock()
+	r.synced = make(map[string]bool)
+}
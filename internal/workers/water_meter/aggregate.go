@@ -0,0 +1,73 @@
+package water_meter_worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+func (wm *WaterLevelMeterWorker) Aggregate(sensorDataCompressed []database.SensorData, interval_ms int, startTime, endTime time.Time) (any, error) {
+	sensorData, _, err := aggregateLevels(sensorDataCompressed, interval_ms, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return sensorData, nil
+}
+
+// aggregateLevels returns every row when interval_ms is 0, otherwise the
+// average level of each interval starting at startTime, the last one possibly
+// partial. counts holds the number of rows behind each entry.
+func aggregateLevels(sensorDataCompressed []database.SensorData, interval_ms int, startTime, endTime time.Time) ([]WaterLevelMeterSensorDataResponse, []int, error) {
+	sensorData := make([]WaterLevelMeterSensorDataResponse, 0)
+	counts := make([]int, 0)
+
+	if interval_ms == 0 {
+		for _, data := range sensorDataCompressed {
+			dataConverted := ConvertCompressedPayloadToSensorDataResponse(data.PayloadVersion, data.Payload)
+			if dataConverted == nil {
+				fmt.Printf("Failed to convert compressed payload to sensor data response for row %d\n", data.ID)
+				continue
+			}
+			dataConverted.Timestamp = data.CreatedAt
+			sensorData = append(sensorData, *dataConverted)
+			counts = append(counts, 1)
+		}
+		return sensorData, counts, nil
+	}
+
+	count, interval, err := workers.AggregateBuckets(interval_ms, startTime, endTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	sensorData = make([]WaterLevelMeterSensorDataResponse, count)
+	counts = make([]int, count)
+	sums := make([]float64, count)
+
+	for _, data := range sensorDataCompressed {
+		index := int(data.CreatedAt.Sub(startTime) / interval)
+		if index < 0 || index >= len(sensorData) {
+			continue
+		}
+
+		dataConverted := ConvertCompressedPayloadToSensorDataResponse(data.PayloadVersion, data.Payload)
+		if dataConverted == nil {
+			fmt.Printf("Failed to convert compressed payload to sensor data response for row %d\n", data.ID)
+			continue
+		}
+
+		sensorData[index].PayloadVersion = dataConverted.PayloadVersion
+		sums[index] += float64(dataConverted.AverageWaterLevelCm)
+		counts[index]++
+	}
+
+	for i := range sensorData {
+		sensorData[i].Timestamp = startTime.Add(time.Duration(i) * interval)
+		if counts[i] > 0 {
+			sensorData[i].AverageWaterLevelCm = float32(sums[i] / float64(counts[i]))
+		}
+	}
+
+	return sensorData, counts, nil
+}
@@ -0,0 +1,23 @@
+package water_meter_worker
+
+import (
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+	wm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter/payloads/water_meter_payload_v1"
+)
+
+func (wm *WaterLevelMeterWorker) DiscoveryEntities() []workers.DiscoveryEntity {
+	return []workers.DiscoveryEntity{
+		{Key: "water_level", Name: "Water Level", Component: workers.DiscoverySensor, DeviceClass: "distance", Unit: "cm", StateClass: "measurement", Icon: "mdi:waves"},
+	}
+}
+
+func (wm *WaterLevelMeterWorker) DiscoveryState(reading *workers.Reading) map[string]any {
+	data, ok := reading.Data.(wm_payload_v1.Data)
+	if !ok {
+		return nil
+	}
+
+	return map[string]any{
+		"water_level": data.Sensors.AverageWaterLevelCm,
+	}
+}
@@ -0,0 +1,129 @@
+package water_meter_payload_v2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	wm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter/payloads/water_meter_payload_v1"
+)
+
+// Payload v2 is a JSON document. Version, fuseId and the water level are
+// required, the timestamp and health sections are optional.
+//
+// Payload example:
+//
+// {
+//   "version": 2,
+//   "clientId": "water-meter-01",
+//   "fuseId": "1287318723677812632",
+//   "timestamp": 1760731200,
+//   "sensors": { "averageWaterLevelCm": 42.7 },
+//   "health": { "wifiStrength": -61, "batteryPercent": 87, "firmwareVersion": "1.4.0", "uptimeSeconds": 3600 }
+// }
+
+const Version = 2
+
+type Payload struct {
+	Version   *int     `json:"version"`
+	ClientId  string   `json:"clientId"`
+	FuseId    *string  `json:"fuseId"`
+	Timestamp *int64   `json:"timestamp"`
+	Sensors   *Sensors `json:"sensors"`
+	Health    *Health  `json:"health"`
+}
+
+type Sensors struct {
+	AverageWaterLevelCm *float32 `json:"averageWaterLevelCm"`
+}
+
+type Health struct {
+	WifiStrength    *int   `json:"wifiStrength"`
+	BatteryPercent  *int   `json:"batteryPercent"`
+	FirmwareVersion string `json:"firmwareVersion"`
+	UptimeSeconds   *int64 `json:"uptimeSeconds"`
+}
+
+func ParsePayload(payload []byte) (*Payload, error) {
+	var message Payload
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, fmt.Errorf("invalid water meter v2 JSON: %w", err)
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid water meter v2 payload: %w", err)
+	}
+
+	return &message, nil
+}
+
+// Validate checks the required fields and value ranges, reporting every
+// problem at once.
+func (p *Payload) Validate() error {
+	var errs []error
+
+	switch {
+	case p.Version == nil:
+		errs = append(errs, errors.New(`missing required field "version"`))
+	case *p.Version != Version:
+		errs = append(errs, fmt.Errorf(`field "version" must be %d, got %d`, Version, *p.Version))
+	}
+
+	switch {
+	case p.FuseId == nil || *p.FuseId == "":
+		errs = append(errs, errors.New(`missing required field "fuseId"`))
+	default:
+		// The fuse id is stored in a BIGINT column.
+		if _, err := strconv.ParseUint(*p.FuseId, 10, 63); err != nil {
+			errs = append(errs, fmt.Errorf(`field "fuseId" must be an unsigned decimal integer, got %q`, *p.FuseId))
+		}
+	}
+
+	if p.Timestamp != nil && *p.Timestamp <= 0 {
+		errs = append(errs, errors.New(`field "timestamp" must be a positive unix timestamp in seconds`))
+	}
+
+	switch {
+	case p.Sensors == nil || p.Sensors.AverageWaterLevelCm == nil:
+		errs = append(errs, errors.New(`missing required field "sensors.averageWaterLevelCm"`))
+	case *p.Sensors.AverageWaterLevelCm < 0:
+		errs = append(errs, errors.New(`field "sensors.averageWaterLevelCm" must not be negative`))
+	}
+
+	if p.Health != nil {
+		if p.Health.WifiStrength == nil {
+			errs = append(errs, errors.New(`missing required field "health.wifiStrength"`))
+		}
+		if p.Health.BatteryPercent == nil {
+			errs = append(errs, errors.New(`missing required field "health.batteryPercent"`))
+		} else if *p.Health.BatteryPercent < 0 || *p.Health.BatteryPercent > 100 {
+			errs = append(errs, errors.New(`field "health.batteryPercent" must be between 0 and 100`))
+		}
+		if p.Health.UptimeSeconds != nil && *p.Health.UptimeSeconds < 0 {
+			errs = append(errs, errors.New(`field "health.uptimeSeconds" must not be negative`))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DeviceTimestamp returns when the device took the reading, or nil when the
+// message carries no timestamp.
+func (p *Payload) DeviceTimestamp() *time.Time {
+	if p.Timestamp == nil {
+		return nil
+	}
+	timestamp := time.Unix(*p.Timestamp, 0).UTC()
+	return &timestamp
+}
+
+// Data converts the payload to the v1 data stored in sensor_data.
+func (p *Payload) Data() wm_payload_v1.Data {
+	return wm_payload_v1.Data{
+		Sensors: wm_payload_v1.SensorData{
+			AverageWaterLevelCm: *p.Sensors.AverageWaterLevelCm,
+		},
+	}
+}
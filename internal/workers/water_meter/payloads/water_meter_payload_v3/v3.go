@@ -0,0 +1,80 @@
+package water_meter_payload_v3
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	wm_payload_v2 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter/payloads/water_meter_payload_v2"
+)
+
+// Payload v3 is the binary form of v2 for battery powered devices: the
+// version byte followed by a CBOR map described in schema.cddl. It is
+// converted to v2 so both share validation and storage.
+
+const Version = 3
+
+type Payload struct {
+	FuseId              *uint64  `cbor:"1,keyasint"`
+	ClientId            string   `cbor:"2,keyasint,omitempty"`
+	Timestamp           *int64   `cbor:"3,keyasint,omitempty"`
+	Health              *Health  `cbor:"4,keyasint,omitempty"`
+	AverageWaterLevelCm *float32 `cbor:"5,keyasint"`
+}
+
+type Health struct {
+	_               struct{} `cbor:",toarray"`
+	WifiStrength    int
+	BatteryPercent  int
+	FirmwareVersion string
+	UptimeSeconds   int64
+}
+
+// ParsePayload decodes a v3 message, including its leading version byte,
+// and returns it as a validated v2 payload.
+func ParsePayload(payload []byte) (*wm_payload_v2.Payload, error) {
+	if len(payload) < 2 || payload[0] != Version {
+		return nil, fmt.Errorf("invalid water meter v3 payload: missing version byte")
+	}
+
+	var message Payload
+	if err := cbor.Unmarshal(payload[1:], &message); err != nil {
+		return nil, fmt.Errorf("invalid water meter v3 CBOR: %w", err)
+	}
+
+	converted := message.toV2()
+	if err := converted.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid water meter v3 payload: %w", err)
+	}
+
+	return converted, nil
+}
+
+func (p *Payload) toV2() *wm_payload_v2.Payload {
+	// Validation expects the v2 version, the v3 version is the selector byte
+	version := wm_payload_v2.Version
+	converted := &wm_payload_v2.Payload{
+		Version:   &version,
+		ClientId:  p.ClientId,
+		Timestamp: p.Timestamp,
+		Sensors: &wm_payload_v2.Sensors{
+			AverageWaterLevelCm: p.AverageWaterLevelCm,
+		},
+	}
+
+	if p.FuseId != nil {
+		fuseId := strconv.FormatUint(*p.FuseId, 10)
+		converted.FuseId = &fuseId
+	}
+
+	if health := p.Health; health != nil {
+		converted.Health = &wm_payload_v2.Health{
+			WifiStrength:    &health.WifiStrength,
+			BatteryPercent:  &health.BatteryPercent,
+			FirmwareVersion: health.FirmwareVersion,
+			UptimeSeconds:   &health.UptimeSeconds,
+		}
+	}
+
+	return converted
+}
@@ -0,0 +1,34 @@
+package water_meter_worker
+
+import (
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
+)
+
+// AggregateTank is Aggregate with the volume metrics of the tank profile. The
+// fill and drain rates compare each point with the previous one, so with an
+// interval they use the averaged levels of consecutive intervals.
+func (wm *WaterLevelMeterWorker) AggregateTank(sensorDataCompressed []database.SensorData, interval_ms int, startTime, endTime time.Time, profile *database.TankProfile) (any, error) {
+	levels, counts, err := aggregateLevels(sensorDataCompressed, interval_ms, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *workers.TankMetrics
+	var previousTime time.Time
+
+	sensorData := make([]WaterLevelMeterTankSensorDataResponse, len(levels))
+	for i, level := range levels {
+		sensorData[i].WaterLevelMeterSensorDataResponse = level
+		if counts[i] == 0 {
+			continue
+		}
+
+		sensorData[i].TankMetrics = workers.NewTankMetrics(profile, float64(level.AverageWaterLevelCm), previous, level.Timestamp.Sub(previousTime))
+		previous, previousTime = sensorData[i].TankMetrics, level.Timestamp
+	}
+
+	return sensorData, nil
+}
@@ -2,6 +2,9 @@ package water_meter_worker
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
 	wm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter/payloads/water_meter_payload_v1"
 )
 
@@ -19,9 +22,18 @@ type WaterLevelMeterSensorData struct {
 
 type WaterLevelMeterSensorDataResponse struct {
 	PayloadVersion int `json:"v"`
+	// Timestamp is when the row was received, or the start of its interval
+	Timestamp time.Time `json:"timestamp"`
 	WaterLevelMeterSensorData
 }
 
+// WaterLevelMeterTankSensorDataResponse is returned for devices with a tank
+// profile. TankMetrics is nil for intervals without readings.
+type WaterLevelMeterTankSensorDataResponse struct {
+	WaterLevelMeterSensorDataResponse
+	*workers.TankMetrics
+}
+
 func CalculateSeverityLevel(value int) SeverityLevel {
 	switch {
 	case value >= 80:
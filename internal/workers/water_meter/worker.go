@@ -1,15 +1,16 @@
 package water_meter_worker
 
 import (
-	"context"
 	"fmt"
 	"strconv"
 	"strings"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/database"
 	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/services"
+	"github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers"
 	wm_payload_v1 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter/payloads/water_meter_payload_v1"
+	wm_payload_v2 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter/payloads/water_meter_payload_v2"
+	wm_payload_v3 "github.com/matheustavarestrindade/mqtt-home-server-worker/internal/workers/water_meter/payloads/water_meter_payload_v3"
 )
 
 const WaterLevelMeterTopicID = 1
@@ -21,77 +22,128 @@ const DeviceType = "water-level-meter"
 // List of suported Paylaods versions
 const (
 	WaterMeterMessageV1 = 1
+	// V2 is JSON, detected by the leading '{'
+	WaterMeterMessageV2 = 2
+	// V3 is CBOR, detected by the leading version byte 0x03
+	WaterMeterMessageV3 = 3
 )
 
-type WaterLevelMeterListener struct {
-	db     *database.Database
+type WaterLevelMeterWorker struct {
 	client *services.MQTTClient
 }
 
-func NewWaterLevelMeterListener(db *database.Database, client *services.MQTTClient) *WaterLevelMeterListener {
-	hm := &WaterLevelMeterListener{
-		db:     db,
+func NewWaterLevelMeterWorker(client *services.MQTTClient) *WaterLevelMeterWorker {
+	return &WaterLevelMeterWorker{
 		client: client,
 	}
+}
 
-	client.Subscribe("water-meter/sensors", hm.Handler)
-	return hm
+func (wm *WaterLevelMeterWorker) Info() workers.DeviceInfo {
+	return workers.DeviceInfo{
+		Type:        DeviceType,
+		Name:        DeviceName,
+		Description: DeviceDescription,
+		TopicID:     WaterLevelMeterTopicID,
+	}
 }
 
-func (wm *WaterLevelMeterListener) Handler(msg mqtt.Message) {
+func (wm *WaterLevelMeterWorker) Topics() []string {
+	return []string{"water-meter/sensors", "water-meter/+/sensors"}
+}
 
-	fmt.Printf("Received message on topic %s: %s\n", msg.Topic(), string(msg.Payload()))
+func (wm *WaterLevelMeterWorker) Parse(payload []byte) (*workers.Reading, error) {
+	if len(payload) > 0 && payload[0] == '{' {
+		message, err := wm_payload_v2.ParsePayload(payload)
+		if err != nil {
+			return nil, err
+		}
+		return readingFromV2(message, WaterMeterMessageV2), nil
+	}
 
-	var payload string = string(msg.Payload())
-	var parts = strings.Split(payload, ";")
+	if len(payload) > 0 && payload[0] == WaterMeterMessageV3 {
+		message, err := wm_payload_v3.ParsePayload(payload)
+		if err != nil {
+			return nil, err
+		}
+		return readingFromV2(message, WaterMeterMessageV3), nil
+	}
+
+	var parts = strings.Split(string(payload), ";")
 
-	if len(parts) < 1 {
-		fmt.Printf("Invalid water meter message format: %s\n", payload)
-		return
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid water meter message format: %s", payload)
 	}
 
 	version, err := strconv.Atoi(parts[0])
 	if err != nil {
-		fmt.Printf("Invalid water meter message version: %s\n", parts[0])
-		return
+		return nil, fmt.Errorf("invalid water meter message version: %s", parts[0])
 	}
 
-	var compressedData string
-	var fuseID string
-	var payloadVersion int = version
-
 	switch version {
 	case WaterMeterMessageV1:
 		message, err := wm_payload_v1.ParsePayload(parts)
 		if err != nil {
-			fmt.Printf("Failed to parse water meter message: %v\n", err)
-			return
+			return nil, err
 		}
-		fuseID = message.FuseId
-
-		fmt.Printf("Parsed water meter v1 message: %+v\n", message)
-		compressedData, err = wm_payload_v1.CompressDataToDatabase(message.Data)
+		health, err := workers.ParseHealthSection(parts[3:])
 		if err != nil {
-			fmt.Printf("Failed to compress water meter v1 data: %v\n", err)
-			return
+			return nil, err
 		}
+		return &workers.Reading{
+			FuseID:         message.FuseId,
+			ClientID:       message.ClientId,
+			PayloadVersion: version,
+			Data:           message.Data,
+			Health:         health,
+		}, nil
 	default:
-		fmt.Printf("Unsupported water meter message version: %d\n", version)
-		return
+		return nil, fmt.Errorf("unsupported water meter message version: %d", version)
 	}
+}
 
-	sr := wm.db.SensorRepository()
-	dr := wm.db.DeviceRepository()
+func (wm *WaterLevelMeterWorker) Encode(reading *workers.Reading) (int, string, error) {
+	data, ok := reading.Data.(wm_payload_v1.Data)
+	if !ok {
+		return 0, "", fmt.Errorf("unexpected water meter data type %T", reading.Data)
+	}
 
-	device, err := dr.CreateAndGetDeviceIfDoesNotExist(fuseID, DeviceName, DeviceDescription, "Unknown", DeviceType, 0, 0)
+	compressedData, err := wm_payload_v1.CompressDataToDatabase(data)
 	if err != nil {
-		fmt.Printf("Failed to insert device: %v\n", err)
-		return
+		return 0, "", err
 	}
 
-	err = sr.InsertSensorData(context.Background(), device.ID, WaterLevelMeterTopicID, compressedData, payloadVersion)
-	if err != nil {
-		fmt.Printf("Failed to insert sensor data for device %s: %v\n", fuseID, err)
-		return
+	return WaterMeterMessageV1, compressedData, nil
+}
+
+func (wm *WaterLevelMeterWorker) Decode(payloadVersion int, payload string) (any, error) {
+	response := ConvertCompressedPayloadToSensorDataResponse(payloadVersion, payload)
+	if response == nil {
+		return nil, fmt.Errorf("failed to decode water meter payload version %d", payloadVersion)
+	}
+	return response, nil
+}
+
+// readingFromV2 converts a validated v2 payload, also used for the binary v3
+// payloads.
+func readingFromV2(message *wm_payload_v2.Payload, version int) *workers.Reading {
+	reading := &workers.Reading{
+		FuseID:          *message.FuseId,
+		ClientID:        message.ClientId,
+		PayloadVersion:  version,
+		Data:            message.Data(),
+		DeviceTimestamp: message.DeviceTimestamp(),
+	}
+
+	if health := message.Health; health != nil {
+		reading.Health = &database.DeviceHealth{
+			WifiStrength:    *health.WifiStrength,
+			BatteryPercent:  *health.BatteryPercent,
+			FirmwareVersion: health.FirmwareVersion,
+		}
+		if health.UptimeSeconds != nil {
+			reading.Health.UptimeSeconds = *health.UptimeSeconds
+		}
 	}
+
+	return reading
 }